@@ -3,8 +3,9 @@ module github.com/grafana/grafana-starter-datasource-backend
 go 1.15
 
 require (
-	github.com/apache/arrow/go/arrow v0.0.0-20210612225111-27d89a99a938 // indirect
+	github.com/apache/arrow/go/arrow v0.0.0-20210612225111-27d89a99a938
 	github.com/fatih/color v1.12.0 // indirect
+	github.com/fraugster/parquet-go v0.12.0
 	github.com/google/flatbuffers v2.0.0+incompatible // indirect
 	github.com/google/go-cmp v0.5.6 // indirect
 	github.com/grafana/grafana-plugin-sdk-go v0.105.0
@@ -17,9 +18,13 @@ require (
 	github.com/mitchellh/go-testing-interface v1.14.1 // indirect
 	github.com/oklog/run v1.1.0 // indirect
 	github.com/pierrec/lz4/v4 v4.1.7 // indirect
+	github.com/prometheus/client_golang v1.11.0
 	github.com/prometheus/common v0.29.0 // indirect
-	github.com/vertica/vertica-sql-go v1.1.1
+	github.com/vertica/vertica-sql-go v1.3.3
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	golang.org/x/net v0.0.0-20210610132358-84b48f89b13b // indirect
-	golang.org/x/sys v0.0.0-20210611083646-a4fc73990273 // indirect
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a // indirect
 	google.golang.org/genproto v0.0.0-20210611144927-798beca9d670 // indirect
 )
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// adhocFilter is a filter of a grafana ad-hoc filter variable, applied to every query of the data source.
+type adhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// adhocRequest is the body of the ad-hoc resource routes, the queries the keys are taken from and the key of the values.
+type adhocRequest struct {
+	Queries []queryModel `json:"queries"`
+	Key     string       `json:"key,omitempty"`
+}
+
+// defaults fills in the fields the frontend may leave out of the panel queries.
+func (req *adhocRequest) defaults() {
+	for i := range req.Queries {
+		req.Queries[i].defaults()
+	}
+}
+
+// adhocOption is a key or value offered by the ad-hoc filter variable.
+type adhocOption struct {
+	Text string `json:"text"`
+}
+
+// tableColumn is a column of a table referenced by a query.
+type tableColumn struct {
+	Schema string
+	Table  string
+	Column string
+}
+
+// maxAdhocValues limits the values offered for a key.
+const maxAdhocValues = 1000
+
+// tableReference matches the tables after FROM and JOIN, with their aliases and comma separated lists.
+var tableReference = regexp.MustCompile(`(?i)\b(?:FROM|JOIN)\s+((?:"[^"]+"|[a-z_][\w$]*)(?:\.(?:"[^"]+"|[a-z_][\w$]*))?(?:\s+(?:AS\s+)?[a-z_][\w$]*)?(?:\s*,\s*(?:"[^"]+"|[a-z_][\w$]*)(?:\.(?:"[^"]+"|[a-z_][\w$]*))?(?:\s+(?:AS\s+)?[a-z_][\w$]*)?)*)`)
+
+// applyAdhocFilters wraps statement in a query filtering its result with the ad-hoc filters:
+// SELECT * FROM (<statement>) AS adhoc WHERE ...
+// keys are quoted as identifiers and values as string literals, vertica casts them to the type of the column.
+func applyAdhocFilters(statement string, filters []adhocFilter) (string, error) {
+	conditions := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		condition, err := filter.condition()
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 0 {
+		return statement, nil
+	}
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s", subquery(statement, "adhoc"), strings.Join(conditions, " AND ")), nil
+}
+
+// condition translates the filter to a vertica condition.
+func (f adhocFilter) condition() (string, error) {
+	if f.Key == "" {
+		return "", fmt.Errorf("ad-hoc filter without key")
+	}
+	column := quoteIdentifier(f.Key)
+	value := quoteLiteral(f.Value)
+	switch f.Operator {
+	case "=", "<", ">":
+		return fmt.Sprintf("%s %s %s", column, f.Operator, value), nil
+	case "!=":
+		return fmt.Sprintf("%s <> %s", column, value), nil
+	case "=~":
+		return fmt.Sprintf("REGEXP_LIKE(%s::VARCHAR, %s)", column, value), nil
+	case "!~":
+		return fmt.Sprintf("NOT REGEXP_LIKE(%s::VARCHAR, %s)", column, value), nil
+	default:
+		return "", fmt.Errorf("ad-hoc filter operator %q is not supported, use =, !=, <, >, =~ or !~", f.Operator)
+	}
+}
+
+// referencedTables returns the schema and name of the tables a statement reads from. string literals and comments
+// are removed first, subqueries are not tables and are skipped. unqualified tables have an empty schema.
+func referencedTables(statement string) [][2]string {
+	statement = stripComments(redactLiterals(statement))
+	tables := make([][2]string, 0)
+	seen := make(map[[2]string]bool)
+	for _, match := range tableReference.FindAllStringSubmatch(statement, -1) {
+		for _, reference := range strings.Split(match[1], ",") {
+			name := strings.Fields(strings.TrimSpace(reference))[0]
+			table := [2]string{"", name}
+			if dot := strings.LastIndex(name, "."); dot > 0 {
+				table = [2]string{name[:dot], name[dot+1:]}
+			}
+			table[0], table[1] = unquoteIdentifier(table[0]), unquoteIdentifier(table[1])
+			if !seen[table] {
+				seen[table] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+	return tables
+}
+
+// stripComments removes the comments of a statement, literals have to be redacted first.
+func stripComments(statement string) string {
+	var out strings.Builder
+	for i := 0; i < len(statement); {
+		switch {
+		case strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				return out.String()
+			}
+			i += end
+		case strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				return out.String()
+			}
+			out.WriteByte(' ')
+			i += end + 4
+		default:
+			out.WriteByte(statement[i])
+			i++
+		}
+	}
+	return out.String()
+}
+
+// unquoteIdentifier returns the name of a quoted identifier, unquoted identifiers are case insensitive and lower cased.
+func unquoteIdentifier(identifier string) string {
+	if len(identifier) >= 2 && identifier[0] == '"' && identifier[len(identifier)-1] == '"' {
+		return strings.ReplaceAll(identifier[1:len(identifier)-1], `""`, `"`)
+	}
+	return strings.ToLower(identifier)
+}
+
+// tableColumnsQuery returns the query listing the columns of tables, views and system tables.
+func tableColumnsQuery(tables [][2]string) string {
+	conditions := make([]string, 0, len(tables))
+	for _, table := range tables {
+		condition := fmt.Sprintf("lower(table_name) = lower(%s)", quoteLiteral(table[1]))
+		if table[0] != "" {
+			condition += fmt.Sprintf(" AND lower(table_schema) = lower(%s)", quoteLiteral(table[0]))
+		}
+		conditions = append(conditions, "("+condition+")")
+	}
+	where := strings.Join(conditions, " OR ")
+	return fmt.Sprintf(`SELECT table_schema, table_name, column_name FROM (
+SELECT table_schema, table_name, column_name, ordinal_position FROM v_catalog.columns
+UNION ALL SELECT table_schema, table_name, column_name, ordinal_position FROM v_catalog.view_columns
+UNION ALL SELECT table_schema, table_name, column_name, ordinal_position FROM v_catalog.system_columns
+) AS c WHERE %s ORDER BY table_schema, table_name, ordinal_position`, where)
+}
+
+// handleAdhocKeys returns the columns of the tables referenced by the queries as ad-hoc filter keys.
+func (td *VerticaDatasource) handleAdhocKeys(w http.ResponseWriter, r *http.Request) {
+	rc, instance, req, ok := td.adhocRequest(w, r)
+	if !ok {
+		return
+	}
+	columns, err := instance.referencedColumns(r.Context(), rc, req.Queries)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	keys := make([]adhocOption, 0, len(columns))
+	seen := make(map[string]bool)
+	for _, column := range columns {
+		if !seen[column.Column] {
+			seen[column.Column] = true
+			keys = append(keys, adhocOption{Text: column.Column})
+		}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// handleAdhocValues returns the distinct values of a key, read from the first referenced table with the column.
+func (td *VerticaDatasource) handleAdhocValues(w http.ResponseWriter, r *http.Request) {
+	rc, instance, req, ok := td.adhocRequest(w, r)
+	if !ok {
+		return
+	}
+	columns, err := instance.referencedColumns(r.Context(), rc, req.Queries)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	values := make([]adhocOption, 0)
+	for _, column := range columns {
+		if !strings.EqualFold(column.Column, req.Key) {
+			continue
+		}
+		statement := fmt.Sprintf("SELECT DISTINCT %[1]s::VARCHAR FROM %[2]s.%[3]s WHERE %[1]s IS NOT NULL ORDER BY 1 LIMIT %[4]d",
+			quoteIdentifier(column.Column), quoteIdentifier(column.Schema), quoteIdentifier(column.Table), maxAdhocValues)
+		texts, err := instance.queryStrings(r.Context(), rc, req.Queries[0], statement)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, text := range texts {
+			values = append(values, adhocOption{Text: text})
+		}
+		break
+	}
+	writeJSON(w, http.StatusOK, values)
+}
+
+// adhocRequest decodes the body of an ad-hoc resource call, writing the error response when it fails.
+func (td *VerticaDatasource) adhocRequest(w http.ResponseWriter, r *http.Request) (requestContext, *instanceSettings, adhocRequest, bool) {
+	var req adhocRequest
+	rc, instance, err := td.resourceRequest(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return rc, nil, req, false
+	}
+	if !decodeRequest(w, r, &req) {
+		return rc, nil, req, false
+	}
+	if len(req.Queries) == 0 {
+		// no panel query ran yet, there are no tables to offer keys of
+		writeJSON(w, http.StatusOK, []adhocOption{})
+		return rc, nil, req, false
+	}
+	return rc, instance, req, true
+}
+
+// referencedColumns returns the columns of the tables the queries read from.
+func (s *instanceSettings) referencedColumns(ctx context.Context, rc requestContext, queries []queryModel) ([]tableColumn, error) {
+	tables := make([][2]string, 0)
+	for _, qm := range queries {
+		if qm.EditorMode == editorModeBuilder && qm.Builder != nil {
+			tables = append(tables, [2]string{qm.Builder.Schema, qm.Builder.Table})
+			continue
+		}
+		tables = append(tables, referencedTables(qm.QueryTemplated)...)
+	}
+	if len(tables) == 0 {
+		return nil, nil
+	}
+	rows, err := s.queryRows(ctx, rc, queries[0], tableColumnsQuery(tables), 3)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]tableColumn, 0, len(rows))
+	for _, row := range rows {
+		columns = append(columns, tableColumn{Schema: row[0], Table: row[1], Column: row[2]})
+	}
+	return columns, nil
+}
+
+// pluginStatement returns the query model of a statement of the plugin run for the query, with only its
+// identity, routing and label. the format, parameters and filters of the query do not apply to the statement.
+func (qm queryModel) pluginStatement(statement string) queryModel {
+	return queryModel{
+		QueryString:    statement,
+		QueryTemplated: statement,
+		RefId:          qm.RefId,
+		ResourcePool:   qm.ResourcePool,
+		Workload:       qm.Workload,
+		DashboardUID:   qm.DashboardUID,
+		PanelID:        qm.PanelID,
+		From:           qm.From,
+		To:             qm.To,
+	}
+}
+
+// queryStrings runs a statement returning a single column of strings.
+func (s *instanceSettings) queryStrings(ctx context.Context, rc requestContext, qm queryModel, statement string) ([]string, error) {
+	rows, err := s.queryRows(ctx, rc, qm, statement, 1)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, 0, len(rows))
+	for _, row := range rows {
+		texts = append(texts, row[0])
+	}
+	return texts, nil
+}
+
+// queryRows runs a statement of the plugin returning string columns, with the user, resource pool and workload of a query model.
+func (s *instanceSettings) queryRows(ctx context.Context, rc requestContext, panel queryModel, statement string, columns int) ([][]string, error) {
+	qm := panel.pluginStatement(statement)
+	prepared, err := s.prepareQuery(rc, qm, qm.RefId)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	connection, rows, err := prepared.run(ctx, prepared.statement, &queryTiming{})
+	if err != nil {
+		s.audit(rc, qm.RefId, prepared.statement, err, errorClassQuery, time.Since(start), 0)
+		return nil, err
+	}
+	defer s.releasePrepared(connection, prepared)
+	defer rows.Close()
+	result := make([][]string, 0)
+	for rows.Next() {
+		row := make([]string, columns)
+		dest := make([]interface{}, columns)
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			s.audit(rc, qm.RefId, prepared.statement, err, errorClassConversion, time.Since(start), len(result))
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	err = rows.Err()
+	s.audit(rc, qm.RefId, prepared.statement, err, errorClassQuery, time.Since(start), len(result))
+	return result, err
+}
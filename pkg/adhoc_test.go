@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestQueryRowsIgnoresPanelFormat(t *testing.T) {
+	statement := "SELECT DISTINCT host FROM metrics WHERE host IS NOT NULL ORDER BY 1 LIMIT 10"
+	db, connector := newFakeDB(fakeStatement{match: "FROM metrics", result: fakeResult{
+		columns: []string{"host"}, types: []string{"VARCHAR"}, rows: [][]driver.Value{{"a"}, {"b"}},
+	}})
+	defer db.Close()
+	instance := &instanceSettings{Db: db, UID: "test"}
+	panel := queryModel{
+		QueryString:    "SELECT time, line FROM logs WHERE host = $host",
+		RefId:          "A",
+		QueryType:      queryTypeLogs,
+		Logs:           &logsOptions{Volume: true},
+		BindParameters: true,
+		Parameters:     map[string][]string{"host": {"a"}},
+		ResultSets:     resultSetsLast,
+		AdhocFilters:   []adhocFilter{{Key: "host", Operator: "=", Value: "a"}},
+		Pagination:     &queryPagination{Limit: 1},
+	}
+	texts, err := instance.queryStrings(context.Background(), requestContext{}, panel, statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || texts[0] != "a" || texts[1] != "b" {
+		t.Errorf("the values are %v, want [a b]", texts)
+	}
+	if len(connector.statements) != 1 || connector.statements[0] != statement {
+		t.Errorf("the statements sent are %q, want the plugin statement unchanged", connector.statements)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeAnnotations is the query type of annotation queries, their result is converted to an annotation frame.
+const queryTypeAnnotations = "Annotations"
+
+// annotationColumns maps the columns of the result of an annotation query to the fields of the annotations,
+// empty names default to the field names time, timeEnd, title, text and tags.
+type annotationColumns struct {
+	Time    string `json:"time,omitempty"`
+	TimeEnd string `json:"timeEnd,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Tags    string `json:"tags,omitempty"`
+}
+
+// annotationColumn is a field of the annotations and the column of the result it is read from.
+type annotationColumn struct {
+	field  string
+	column string
+	mapped bool
+}
+
+// columns returns the column of every annotation field, the configured one or the default.
+func (c *annotationColumns) columns() []annotationColumn {
+	if c == nil {
+		c = &annotationColumns{}
+	}
+	columns := []annotationColumn{
+		{field: "time", column: c.Time},
+		{field: "timeEnd", column: c.TimeEnd},
+		{field: "title", column: c.Title},
+		{field: "text", column: c.Text},
+		{field: "tags", column: c.Tags},
+	}
+	for i := range columns {
+		columns[i].mapped = columns[i].column != ""
+		if !columns[i].mapped {
+			columns[i].column = columns[i].field
+		}
+	}
+	return columns
+}
+
+// annotationFrame converts the result of an annotation query to a frame of annotations. the start time column
+// is required, and a title or text column. an annotation with an end time after its start time is a region.
+// tags are read from comma separated strings or from vertica arrays.
+func annotationFrame(frame *data.Frame, mapping *annotationColumns) (*data.Frame, error) {
+	fields := make(map[string]*data.Field)
+	missing := make([]string, 0)
+	for _, c := range mapping.columns() {
+		field := frameField(frame, c.column)
+		if field == nil && c.field == "time" && !c.mapped {
+			// without a time column the first time column of the result is the start time
+			field = firstTimeField(frame)
+		}
+		if field == nil && (c.mapped || c.field == "time") {
+			missing = append(missing, c.column)
+		}
+		fields[c.field] = field
+	}
+	if fields["title"] == nil && fields["text"] == nil {
+		missing = append(missing, "text or title")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("annotation query result has no column %s, the columns are %s, map the annotation fields to columns of the result",
+			strings.Join(missing, ", "), strings.Join(frameColumns(frame), ", "))
+	}
+
+	rows := frame.Rows()
+	starts := make([]time.Time, 0, rows)
+	ends := make([]*time.Time, 0, rows)
+	regions := make([]bool, 0, rows)
+	titles := make([]*string, 0, rows)
+	texts := make([]*string, 0, rows)
+	tags := make([]*string, 0, rows)
+	for row := 0; row < rows; row++ {
+		start, err := annotationTime(fields["time"], row)
+		if err != nil {
+			return nil, err
+		}
+		// an annotation needs a start time
+		if start == nil {
+			continue
+		}
+		end, err := annotationTime(fields["timeEnd"], row)
+		if err != nil {
+			return nil, err
+		}
+		if end != nil && !end.After(*start) {
+			end = nil
+		}
+		starts = append(starts, *start)
+		ends = append(ends, end)
+		regions = append(regions, end != nil)
+		titles = append(titles, annotationString(fields["title"], row))
+		texts = append(texts, annotationString(fields["text"], row))
+		tags = append(tags, annotationTags(fields["tags"], row))
+	}
+	return data.NewFrame(frame.Name,
+		data.NewField("time", nil, starts),
+		data.NewField("timeEnd", nil, ends),
+		data.NewField("isRegion", nil, regions),
+		data.NewField("title", nil, titles),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	), nil
+}
+
+// frameField returns the field named name, compared case insensitively, or nil.
+func frameField(frame *data.Frame, name string) *data.Field {
+	for _, field := range frame.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// firstTimeField returns the first time field of frame, or nil.
+func firstTimeField(frame *data.Frame) *data.Field {
+	for _, field := range frame.Fields {
+		if field.Type() == data.FieldTypeTime || field.Type() == data.FieldTypeNullableTime {
+			return field
+		}
+	}
+	return nil
+}
+
+// frameColumns returns the field names of frame.
+func frameColumns(frame *data.Frame) []string {
+	names := make([]string, len(frame.Fields))
+	for i, field := range frame.Fields {
+		names[i] = field.Name
+	}
+	return names
+}
+
+// annotationTime reads a time at row of field, a timestamp or epoch milliseconds, nil for null values and without field.
+func annotationTime(field *data.Field, row int) (*time.Time, error) {
+	if field == nil {
+		return nil, nil
+	}
+	value, ok := field.ConcreteAt(row)
+	if !ok {
+		return nil, nil
+	}
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case int64:
+		t = time.Unix(0, v*int64(time.Millisecond))
+	case float64:
+		t = time.Unix(0, int64(v*float64(time.Millisecond)))
+	case string:
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			t = time.Unix(0, ms*int64(time.Millisecond))
+		} else if t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, fmt.Errorf("annotation column %s: %q is not a time, use a timestamp or epoch milliseconds", field.Name, v)
+		}
+	default:
+		return nil, fmt.Errorf("annotation column %s is not a time, use a timestamp or epoch milliseconds", field.Name)
+	}
+	return &t, nil
+}
+
+// annotationString reads the value at row of field as a string, nil for null values and without field.
+func annotationString(field *data.Field, row int) *string {
+	if field == nil {
+		return nil
+	}
+	value, ok := field.ConcreteAt(row)
+	if !ok {
+		return nil
+	}
+	s := fmt.Sprint(value)
+	if t, ok := value.(time.Time); ok {
+		s = t.Format(time.RFC3339Nano)
+	}
+	return &s
+}
+
+// annotationTags reads the tags at row of field, comma separated or a vertica array like ["a","b"], and returns
+// them trimmed and comma separated, the format grafana splits the tags of annotation frames in.
+func annotationTags(field *data.Field, row int) *string {
+	value := annotationString(field, row)
+	if value == nil {
+		return nil
+	}
+	tags := make([]string, 0)
+	for _, tag := range splitTags(*value) {
+		if tag = strings.Trim(strings.TrimSpace(tag), `"'`); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	joined := strings.Join(tags, ",")
+	return &joined
+}
+
+// splitTags splits a vertica array or a comma separated string of tags.
+func splitTags(value string) []string {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return strings.Split(value, ",")
+	}
+	var elements []interface{}
+	if err := json.Unmarshal([]byte(value), &elements); err != nil {
+		return strings.Split(value[1:len(value)-1], ",")
+	}
+	tags := make([]string, 0, len(elements))
+	for _, element := range elements {
+		if element != nil {
+			tags = append(tags, fmt.Sprint(element))
+		}
+	}
+	return tags
+}
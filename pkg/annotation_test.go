@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// annotationResult holds a point annotation with a NULL end time, a region and an annotation whose end is not
+// after its start.
+var annotationResult = fakeResult{
+	columns: []string{"start_time", "end_time", "title", "description", "labels"},
+	types:   []string{"TIMESTAMP", "TIMESTAMP", "VARCHAR", "VARCHAR", "VARCHAR"},
+	rows: [][]driver.Value{
+		{time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), nil, "deploy", nil, "prod, api"},
+		{time.Date(2021, 6, 1, 1, 0, 0, 0, time.UTC), time.Date(2021, 6, 1, 2, 0, 0, 0, time.UTC), "outage", "db down", `["db","p1"]`},
+		{time.Date(2021, 6, 1, 3, 0, 0, 0, time.UTC), time.Date(2021, 6, 1, 3, 0, 0, 0, time.UTC), nil, "restart", nil},
+		{nil, nil, "no start", nil, nil},
+	},
+}
+
+// scanAnnotations scans result like a panel query and converts it to annotations.
+func scanAnnotations(t *testing.T, result fakeResult, mapping *annotationColumns) (*data.Frame, error) {
+	db, _ := newFakeDB(fakeStatement{match: "SELECT", result: result})
+	defer db.Close()
+	rows, err := db.QueryContext(context.Background(), "SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	frame, _, err := scanFrame(rows, "A", &queryObservation{})
+	if err != nil {
+		t.Fatalf("scanning the annotation query failed: %v", err)
+	}
+	return annotationFrame(frame, mapping)
+}
+
+// annotationStrings returns the values of a nullable string field, "<nil>" for NULL.
+func annotationStrings(field *data.Field) []string {
+	values := make([]string, field.Len())
+	for i := range values {
+		values[i] = "<nil>"
+		if value, ok := field.ConcreteAt(i); ok {
+			values[i] = value.(string)
+		}
+	}
+	return values
+}
+
+func TestAnnotationRegions(t *testing.T) {
+	frame, err := scanAnnotations(t, annotationResult, &annotationColumns{
+		Time: "start_time", TimeEnd: "END_TIME", Text: "description", Tags: "labels",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the row without a start time is dropped
+	if frame.Rows() != 3 {
+		t.Fatalf("got %d annotations, want 3", frame.Rows())
+	}
+	regions := make([]bool, frame.Rows())
+	ends := make([]bool, frame.Rows())
+	for row := range regions {
+		regions[row] = frame.Fields[2].At(row).(bool)
+		_, ends[row] = frame.Fields[1].ConcreteAt(row)
+	}
+	if want := []bool{false, true, false}; !reflect.DeepEqual(regions, want) || !reflect.DeepEqual(ends, want) {
+		t.Errorf("got the regions %v and end times %v, want %v", regions, ends, want)
+	}
+	if got, want := annotationStrings(frame.Fields[3]), []string{"deploy", "outage", "<nil>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got the titles %q, want %q", got, want)
+	}
+	if got, want := annotationStrings(frame.Fields[4]), []string{"<nil>", "db down", "restart"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got the texts %q, want %q", got, want)
+	}
+	if got, want := annotationStrings(frame.Fields[5]), []string{"prod,api", "db,p1", "<nil>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got the tags %q, want %q", got, want)
+	}
+}
+
+func TestAnnotationDefaultColumns(t *testing.T) {
+	// without a mapping the fields are read from the columns named like them, the start time from the first time column
+	result := fakeResult{
+		columns: []string{"created", "Title"},
+		types:   []string{"TIMESTAMP", "VARCHAR"},
+		rows:    [][]driver.Value{{time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), "deploy"}},
+	}
+	frame, err := scanAnnotations(t, result, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := annotationStrings(frame.Fields[3]); !reflect.DeepEqual(got, []string{"deploy"}) {
+		t.Errorf("got the titles %q, want [deploy]", got)
+	}
+
+	_, err = scanAnnotations(t, result, &annotationColumns{Text: "body"})
+	if err == nil || !strings.Contains(err.Error(), "no column body") {
+		t.Errorf("a mapping to a missing column returned %v, want the missing column error", err)
+	}
+	result.columns[1] = "message"
+	if _, err = scanAnnotations(t, result, nil); err == nil || !strings.Contains(err.Error(), "text or title") {
+		t.Errorf("a result without text and title returned %v, want the missing column error", err)
+	}
+}
+
+func TestAnnotationTime(t *testing.T) {
+	want := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, field := range []*data.Field{
+		data.NewField("t", nil, []time.Time{want}),
+		data.NewField("t", nil, []*time.Time{&want}),
+		data.NewField("t", nil, []int64{want.UnixNano() / int64(time.Millisecond)}),
+		data.NewField("t", nil, []float64{float64(want.UnixNano() / int64(time.Millisecond))}),
+		data.NewField("t", nil, []string{"1622505600000"}),
+		data.NewField("t", nil, []string{"2021-06-01T00:00:00Z"}),
+	} {
+		got, err := annotationTime(field, 0)
+		if err != nil || got == nil || !got.Equal(want) {
+			t.Errorf("the %s time is %v, %v, want %v", field.Type(), got, err, want)
+		}
+	}
+	if got, err := annotationTime(data.NewField("t", nil, []*int64{nil}), 0); got != nil || err != nil {
+		t.Errorf("a NULL time is %v, %v, want nil", got, err)
+	}
+	if _, err := annotationTime(data.NewField("t", nil, []string{"yesterday"}), 0); err == nil {
+		t.Error("a text that is not a time was accepted")
+	}
+	if _, err := annotationTime(data.NewField("t", nil, []bool{true}), 0); err == nil {
+		t.Error("a boolean time was accepted")
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	for value, want := range map[string][]string{
+		"a,b":            {"a", "b"},
+		" a , b ,":       {"a", "b"},
+		`["a","b"]`:      {"a", "b"},
+		`[1,null,"b c"]`: {"1", "b c"},
+		"['a','b']":      {"a", "b"},
+		"":               {},
+	} {
+		tags := annotationTags(data.NewField("tags", nil, []string{value}), 0)
+		got := []string{}
+		if *tags != "" {
+			got = strings.Split(*tags, ",")
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("the tags of %q are %q, want %q", value, got, want)
+		}
+	}
+}
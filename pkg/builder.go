@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// editor modes of a query, code is the sql editor and the default.
+const (
+	editorModeCode    = "code"
+	editorModeBuilder = "builder"
+)
+
+// builderQuery is the query of the visual query builder, rendered to sql by the backend.
+type builderQuery struct {
+	Schema  string          `json:"schema,omitempty"`
+	Table   string          `json:"table"`
+	Columns []builderColumn `json:"columns"`
+	// TimeColumn is grouped into slices of the query interval when columns are aggregated, and limited to the time range
+	TimeColumn string         `json:"timeColumn,omitempty"`
+	GroupBy    []string       `json:"groupBy,omitempty"`
+	Filters    []adhocFilter  `json:"filters,omitempty"`
+	OrderBy    []builderOrder `json:"orderBy,omitempty"`
+	Limit      int            `json:"limit,omitempty"`
+}
+
+// builderColumn is a selected column, with an optional aggregation.
+type builderColumn struct {
+	Name        string `json:"name"`
+	Aggregation string `json:"aggregation,omitempty"`
+	Alias       string `json:"alias,omitempty"`
+}
+
+// builderOrder is a column of the order by clause.
+type builderOrder struct {
+	Column    string `json:"column"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// aggregations of the query builder and their vertica function.
+var builderAggregations = map[string]string{
+	"count":          "COUNT(%s)",
+	"count_distinct": "COUNT(DISTINCT %s)",
+	"sum":            "SUM(%s)",
+	"avg":            "AVG(%s)",
+	"min":            "MIN(%s)",
+	"max":            "MAX(%s)",
+}
+
+// builderTimeAlias is the name of the time column of builder queries.
+const builderTimeAlias = "time"
+
+// builderStatement returns the statement of a query model: the sql of the builder in builder mode, the templated sql otherwise.
+func (qm queryModel) builderStatement() (string, error) {
+	if qm.EditorMode != editorModeBuilder {
+		return qm.QueryTemplated, nil
+	}
+	if qm.Builder == nil {
+		return "", fmt.Errorf("query builder mode without a builder query")
+	}
+	return qm.Builder.sql(qm)
+}
+
+// sql renders the builder query. identifiers are quoted and values are string literals, no input of the
+// builder reaches the statement unquoted. with a time column the query is limited to the time range of qm
+// and, when columns are aggregated, grouped with time_slice into slices of the query interval.
+func (b builderQuery) sql(qm queryModel) (string, error) {
+	if b.Table == "" {
+		return "", fmt.Errorf("query builder: no table selected")
+	}
+	if len(b.Columns) == 0 && b.TimeColumn == "" {
+		return "", fmt.Errorf("query builder: no columns selected")
+	}
+	aggregated := false
+	for _, column := range b.Columns {
+		if column.Aggregation != "" {
+			aggregated = true
+		}
+	}
+
+	selects := make([]string, 0, len(b.Columns)+1)
+	groupBy := make([]string, 0)
+	if b.TimeColumn != "" {
+		timeColumn := quoteIdentifier(b.TimeColumn)
+		if aggregated {
+			interval := qm.IntervalMs
+			if interval <= 0 {
+				interval = 1000
+			}
+			selects = append(selects, fmt.Sprintf("time_slice(%s, %d, 'MILLISECOND', 'START') AS %s", timeColumn, interval, builderTimeAlias))
+			groupBy = append(groupBy, "1")
+		} else {
+			selects = append(selects, fmt.Sprintf("%s AS %s", timeColumn, builderTimeAlias))
+		}
+	}
+	for _, column := range b.Columns {
+		if column.Name == "" {
+			return "", fmt.Errorf("query builder: column without name")
+		}
+		aggregation := strings.ToLower(column.Aggregation)
+		expression := quoteIdentifier(column.Name)
+		if column.Name == "*" && (aggregation == "" || aggregation == "count") {
+			expression = "*"
+		}
+		if aggregation != "" {
+			format, ok := builderAggregations[aggregation]
+			if !ok {
+				return "", fmt.Errorf("query builder: aggregation %q is not supported", column.Aggregation)
+			}
+			expression = fmt.Sprintf(format, expression)
+		} else if aggregated {
+			if column.Name == "*" {
+				return "", fmt.Errorf("query builder: * has to be counted when other columns are aggregated")
+			}
+			groupBy = append(groupBy, quoteIdentifier(column.Name))
+		}
+		if column.Alias != "" {
+			expression = fmt.Sprintf("%s AS %s", expression, quoteIdentifier(column.Alias))
+		}
+		selects = append(selects, expression)
+	}
+	for _, column := range b.GroupBy {
+		if column = quoteIdentifier(column); !containsString(groupBy, column) {
+			groupBy = append(groupBy, column)
+		}
+	}
+
+	table := quoteIdentifier(b.Table)
+	if b.Schema != "" {
+		table = quoteIdentifier(b.Schema) + "." + table
+	}
+	statement := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), table)
+
+	conditions := make([]string, 0, len(b.Filters)+1)
+	if b.TimeColumn != "" && !qm.From.IsZero() && !qm.To.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("%s BETWEEN TO_TIMESTAMP(%d / 1000) AND TO_TIMESTAMP(%d / 1000)",
+			quoteIdentifier(b.TimeColumn), qm.From.UnixNano()/1e6, qm.To.UnixNano()/1e6))
+	}
+	for _, filter := range b.Filters {
+		condition, err := filter.condition()
+		if err != nil {
+			return "", fmt.Errorf("query builder: %w", err)
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if len(groupBy) > 0 && aggregated {
+		statement += " GROUP BY " + strings.Join(groupBy, ", ")
+	}
+
+	orderBy := make([]string, 0, len(b.OrderBy)+1)
+	for _, order := range b.OrderBy {
+		direction := strings.ToUpper(order.Direction)
+		if direction == "" {
+			direction = "ASC"
+		}
+		if direction != "ASC" && direction != "DESC" {
+			return "", fmt.Errorf("query builder: order direction %q is not supported, use ASC or DESC", order.Direction)
+		}
+		orderBy = append(orderBy, fmt.Sprintf("%s %s", quoteIdentifier(order.Column), direction))
+	}
+	if len(orderBy) == 0 && b.TimeColumn != "" {
+		// time series have to be sorted by time
+		orderBy = append(orderBy, builderTimeAlias+" ASC")
+	}
+	if len(orderBy) > 0 {
+		statement += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+	if b.Limit < 0 {
+		return "", fmt.Errorf("query builder: limit %d is negative", b.Limit)
+	}
+	if b.Limit > 0 {
+		statement += fmt.Sprintf(" LIMIT %d", b.Limit)
+	}
+	return statement, nil
+}
+
+// containsString reports whether values holds value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// handleBuilderSQL returns the sql of a builder query, for the query editor to show it.
+func (td *VerticaDatasource) handleBuilderSQL(w http.ResponseWriter, r *http.Request) {
+	var qm queryModel
+	if !decodeRequest(w, r, &qm) {
+		return
+	}
+	qm.EditorMode = editorModeBuilder
+	statement, err := qm.builderStatement()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"sql": statement})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuilderSQL(t *testing.T) {
+	from := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeRange := queryModel{From: from, To: from.Add(time.Hour), IntervalMs: 60000}
+	for _, test := range []struct {
+		name    string
+		builder builderQuery
+		qm      queryModel
+		want    string
+	}{
+		{
+			name:    "columns",
+			builder: builderQuery{Schema: "s", Table: "t", Columns: []builderColumn{{Name: "a"}, {Name: "b", Alias: "B"}}, Limit: 10},
+			want:    `SELECT "a", "b" AS "B" FROM "s"."t" LIMIT 10`,
+		},
+		{
+			name: "aggregation per time slice",
+			builder: builderQuery{
+				Table:      "metrics",
+				TimeColumn: "ts",
+				Columns:    []builderColumn{{Name: "host"}, {Name: "cpu", Aggregation: "avg", Alias: "avg cpu"}},
+				Filters:    []adhocFilter{{Key: "host", Operator: "!=", Value: "it's"}},
+			},
+			qm: timeRange,
+			want: `SELECT time_slice("ts", 60000, 'MILLISECOND', 'START') AS time, "host", AVG("cpu") AS "avg cpu" FROM "metrics"` +
+				` WHERE "ts" BETWEEN TO_TIMESTAMP(1622505600000 / 1000) AND TO_TIMESTAMP(1622509200000 / 1000) AND "host" <> 'it''s'` +
+				` GROUP BY 1, "host" ORDER BY time ASC`,
+		},
+		{
+			name:    "time slice of one second without interval",
+			builder: builderQuery{Table: "t", TimeColumn: "ts", Columns: []builderColumn{{Name: "v", Aggregation: "MAX"}}},
+			want:    `SELECT time_slice("ts", 1000, 'MILLISECOND', 'START') AS time, MAX("v") FROM "t" GROUP BY 1 ORDER BY time ASC`,
+		},
+		{
+			name:    "time column without aggregation",
+			builder: builderQuery{Table: "t", TimeColumn: "ts", Columns: []builderColumn{{Name: "v"}}},
+			qm:      timeRange,
+			want:    `SELECT "ts" AS time, "v" FROM "t" WHERE "ts" BETWEEN TO_TIMESTAMP(1622505600000 / 1000) AND TO_TIMESTAMP(1622509200000 / 1000) ORDER BY time ASC`,
+		},
+		{
+			name: "counts grouped and ordered",
+			builder: builderQuery{
+				Table:   "events",
+				Columns: []builderColumn{{Name: "host"}, {Name: "*", Aggregation: "count"}, {Name: "*", Aggregation: "COUNT", Alias: "n"}, {Name: "user", Aggregation: "count_distinct"}},
+				GroupBy: []string{"host", "region"},
+				OrderBy: []builderOrder{{Column: "host", Direction: "desc"}, {Column: "region"}},
+				Limit:   5,
+			},
+			want: `SELECT "host", COUNT(*), COUNT(*) AS "n", COUNT(DISTINCT "user") FROM "events" GROUP BY "host", "region" ORDER BY "host" DESC, "region" ASC LIMIT 5`,
+		},
+		{
+			name:    "group by without aggregation",
+			builder: builderQuery{Table: "t", Columns: []builderColumn{{Name: "*"}}, GroupBy: []string{"a"}},
+			want:    `SELECT * FROM "t"`,
+		},
+		{
+			name:    "quoted identifiers",
+			builder: builderQuery{Schema: `s"`, Table: `t"; DROP TABLE x; --`, Columns: []builderColumn{{Name: `a"b`, Alias: `c"d`}}},
+			want:    `SELECT "a""b" AS "c""d" FROM "s"""."t""; DROP TABLE x; --"`,
+		},
+	} {
+		got, err := test.builder.sql(test.qm)
+		if err != nil || got != test.want {
+			t.Errorf("%s: got %s, %v\nwant %s", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestBuilderSQLErrors(t *testing.T) {
+	for _, test := range []struct {
+		builder builderQuery
+		err     string
+	}{
+		{builderQuery{Columns: []builderColumn{{Name: "a"}}}, "no table"},
+		{builderQuery{Table: "t"}, "no columns"},
+		{builderQuery{Table: "t", Columns: []builderColumn{{Alias: "a"}}}, "column without name"},
+		{builderQuery{Table: "t", Columns: []builderColumn{{Name: "a", Aggregation: "median"}}}, `aggregation "median"`},
+		{builderQuery{Table: "t", Columns: []builderColumn{{Name: "*"}, {Name: "a", Aggregation: "sum"}}}, "* has to be counted"},
+		{builderQuery{Table: "t", Columns: []builderColumn{{Name: "a"}}, OrderBy: []builderOrder{{Column: "a", Direction: "up"}}}, `direction "up"`},
+		{builderQuery{Table: "t", Columns: []builderColumn{{Name: "a"}}, Limit: -1}, "negative"},
+		{builderQuery{Table: "t", Columns: []builderColumn{{Name: "a"}}, Filters: []adhocFilter{{Key: "a", Operator: "LIKE"}}}, `operator "LIKE"`},
+	} {
+		statement, err := test.builder.sql(queryModel{})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("the builder %+v returned %s, %v, want the error %q", test.builder, statement, err, test.err)
+		}
+	}
+}
+
+func TestBuilderStatement(t *testing.T) {
+	qm := queryModel{QueryTemplated: "SELECT 1"}
+	if statement, err := qm.builderStatement(); err != nil || statement != "SELECT 1" {
+		t.Errorf("the code mode statement is %s, %v, want the templated sql", statement, err)
+	}
+	qm.EditorMode = editorModeBuilder
+	if _, err := qm.builderStatement(); err == nil {
+		t.Error("builder mode without a builder query was accepted")
+	}
+	qm.Builder = &builderQuery{Table: "t", Columns: []builderColumn{{Name: "a"}}}
+	if statement, err := qm.builderStatement(); err != nil || statement != `SELECT "a" FROM "t"` {
+		t.Errorf("the builder mode statement is %s, %v", statement, err)
+	}
+}
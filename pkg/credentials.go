@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// oauthClientSecretKey is the secure json key of the oauth client secret.
+const oauthClientSecretKey = "oauthClientSecret"
+
+// tokenRefreshMargin is how long before expiry an access token is refreshed
+// and connections opened with it are recycled.
+const tokenRefreshMargin = time.Minute
+
+// tokenRequestTimeout is how long a request to the oauth token endpoint may take.
+const tokenRequestTimeout = 30 * time.Second
+
+// credentials are the secrets a new connection logs in with.
+type credentials struct {
+	Password    string
+	AccessToken string
+}
+
+// credentialProvider supplies the credentials whenever a new connection is opened.
+type credentialProvider interface {
+	Credentials(ctx context.Context) (credentials, error)
+}
+
+// staticCredentials are credentials which do not change for the lifetime of the instance.
+type staticCredentials credentials
+
+func (c staticCredentials) Credentials(ctx context.Context) (credentials, error) {
+	return credentials(c), nil
+}
+
+// oauthConfig configures oauth access token authentication, available since vertica 12.
+// tokens are either requested from TokenURL with the client credentials grant,
+// or the oauth token of the grafana user is forwarded.
+type oauthConfig struct {
+	TokenURL         string   `json:"tokenUrl,omitempty"`
+	ClientID         string   `json:"clientId,omitempty"`
+	Scopes           []string `json:"scopes,omitempty"`
+	ForwardUserToken bool     `json:"forwardUserToken,omitempty"`
+}
+
+// clientCredentialsToken requests access tokens with the oauth client credentials grant.
+// the token is cached and refreshed shortly before it expires.
+type clientCredentialsToken struct {
+	httpClient   *http.Client
+	config       oauthConfig
+	clientSecret string
+	// onRefresh is called with the lifetime of every new token
+	onRefresh func(lifetime time.Duration)
+	// datasourceUID labels the cache metrics of the token
+	datasourceUID string
+
+	mtx    sync.Mutex
+	token  string
+	expiry time.Time
+	// pending is the running token request, which concurrent connections wait for
+	pending *tokenRequest
+}
+
+// tokenRequest is a request for a new access token, done is closed when token and err are set.
+type tokenRequest struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// Credentials returns the cached token or requests a new one. the token endpoint is called without holding the
+// lock, by a single caller, the others wait for its result. the request is not bound to the context of the caller
+// which started it, so a canceled query does not fail the connections waiting for the token.
+func (t *clientCredentialsToken) Credentials(ctx context.Context) (credentials, error) {
+	t.mtx.Lock()
+	cached := t.token != "" && (t.expiry.IsZero() || time.Until(t.expiry) > tokenRefreshMargin)
+	pending := t.pending
+	observeCache(t.datasourceUID, cacheToken, cached || pending != nil)
+	if cached {
+		token := t.token
+		t.mtx.Unlock()
+		return credentials{AccessToken: token}, nil
+	}
+	if pending == nil {
+		pending = &tokenRequest{done: make(chan struct{})}
+		t.pending = pending
+		go t.refresh(pending)
+	}
+	t.mtx.Unlock()
+
+	select {
+	case <-pending.done:
+	case <-ctx.Done():
+		return credentials{}, ctx.Err()
+	}
+	if pending.err != nil {
+		return credentials{}, fmt.Errorf("failed to get oauth access token: %w", pending.err)
+	}
+	return credentials{AccessToken: pending.token}, nil
+}
+
+// refresh requests a new access token for pending and caches it.
+func (t *clientCredentialsToken) refresh(pending *tokenRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenRequestTimeout)
+	defer cancel()
+	token, lifetime, err := t.requestToken(ctx)
+
+	t.mtx.Lock()
+	if err == nil {
+		t.token = token
+		t.expiry = time.Time{}
+		if lifetime > 0 {
+			t.expiry = time.Now().Add(lifetime)
+		}
+	}
+	t.pending = nil
+	t.mtx.Unlock()
+	if err == nil {
+		if lifetime > 0 && t.onRefresh != nil {
+			t.onRefresh(lifetime)
+		}
+		log.DefaultLogger.Debug("new oauth access token", "lifetime", lifetime)
+	}
+	pending.token, pending.err = token, err
+	close(pending.done)
+}
+
+func (t *clientCredentialsToken) requestToken(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", t.config.ClientID)
+	form.Set("client_secret", t.clientSecret)
+	if len(t.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.config.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := t.httpClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", 0, fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("token response has no access_token")
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// forwardedToken holds the latest oauth token a grafana user sent with a request.
+type forwardedToken struct {
+	mtx   sync.Mutex
+	token string
+}
+
+func (t *forwardedToken) set(token string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.token = token
+}
+
+func (t *forwardedToken) Credentials(ctx context.Context) (credentials, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.token == "" {
+		return credentials{}, errors.New("no oauth token was forwarded by grafana")
+	}
+	return credentials{AccessToken: t.token}, nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header value.
+func bearerToken(authorization string) string {
+	parts := strings.SplitN(strings.TrimSpace(authorization), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// defaultPasswordRefreshInterval is how often a password file or environment variable is re-read by default.
+const defaultPasswordRefreshInterval = time.Minute
+
+// environment variables of the grafana server limiting the password sources. the data source settings can be
+// edited by every org admin, they only name files in the directory and variables with the prefix the operator allows.
+// a source is disabled while its variable is not set.
+const (
+	passwordDirEnv       = "VERTICA_PASSWORD_DIR"
+	passwordEnvPrefixEnv = "VERTICA_PASSWORD_ENV_PREFIX"
+)
+
+// passwordSource configures reading the password from a file or environment variable instead of the secure json data,
+// so the password can be rotated, e.g. by a vault sidecar, without updating the data source.
+type passwordSource struct {
+	File string `json:"file,omitempty"`
+	Env  string `json:"env,omitempty"`
+	// RefreshInterval is the number of seconds after which the password is read again
+	RefreshInterval int `json:"refreshInterval,omitempty"`
+}
+
+// path returns the password file, which has to be in the directory of VERTICA_PASSWORD_DIR after resolving symlinks.
+// relative files are in the directory.
+func (source passwordSource) path() (string, error) {
+	allowed := os.Getenv(passwordDirEnv)
+	if allowed == "" {
+		return "", fmt.Errorf("password files are disabled, the grafana server has to allow a directory with %s", passwordDirEnv)
+	}
+	dir, err := filepath.EvalSymlinks(allowed)
+	if err != nil {
+		return "", fmt.Errorf("the password directory of %s is not readable: %w", passwordDirEnv, err)
+	}
+	file := source.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("password file %s is outside of the directory %s allowed by %s", source.File, allowed, passwordDirEnv)
+	}
+	return resolved, nil
+}
+
+// env returns the password environment variable, which has to start with the prefix of VERTICA_PASSWORD_ENV_PREFIX.
+func (source passwordSource) env() (string, error) {
+	prefix := os.Getenv(passwordEnvPrefixEnv)
+	if prefix == "" {
+		return "", fmt.Errorf("password environment variables are disabled, the grafana server has to allow a prefix with %s", passwordEnvPrefixEnv)
+	}
+	if !strings.HasPrefix(source.Env, prefix) || source.Env == passwordDirEnv || source.Env == passwordEnvPrefixEnv {
+		return "", fmt.Errorf("password environment variable %s does not start with the prefix %s allowed by %s", source.Env, prefix, passwordEnvPrefixEnv)
+	}
+	return source.Env, nil
+}
+
+// credentialRefresher is implemented by providers whose credentials can be read again on demand,
+// the connector refreshes the credentials when a connection could not be opened with them.
+type credentialRefresher interface {
+	// Refresh reads the credentials again and reports whether they changed.
+	Refresh() bool
+}
+
+// rotatingPassword reads the password from a file or environment variable, again every refresh interval.
+// existing connections keep running, connections opened after a change log in with the new password.
+type rotatingPassword struct {
+	source   passwordSource
+	interval time.Duration
+	// onChange is called after the password changed
+	onChange func()
+
+	mtx      sync.Mutex
+	password string
+	readAt   time.Time
+}
+
+func newRotatingPassword(source passwordSource) (*rotatingPassword, error) {
+	p := &rotatingPassword{
+		source:   source,
+		interval: defaultPasswordRefreshInterval,
+	}
+	if source.RefreshInterval > 0 {
+		p.interval = time.Duration(source.RefreshInterval) * time.Second
+	}
+	password, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	p.password = password
+	p.readAt = time.Now()
+	return p, nil
+}
+
+// read returns the current password from the file or environment variable. the limits of the operator are checked
+// on every read, a symlink changed to point outside of the directory is refused.
+func (p *rotatingPassword) read() (string, error) {
+	if p.source.File != "" {
+		file, err := p.source.path()
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		// files written by secret managers usually end with a newline
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	env, err := p.source.env()
+	if err != nil {
+		return "", err
+	}
+	password, ok := os.LookupEnv(env)
+	if !ok {
+		return "", fmt.Errorf("password environment variable %s is not set", env)
+	}
+	return password, nil
+}
+
+func (p *rotatingPassword) Credentials(ctx context.Context) (credentials, error) {
+	p.mtx.Lock()
+	due := time.Since(p.readAt) > p.interval
+	p.mtx.Unlock()
+	if due {
+		p.Refresh()
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return credentials{Password: p.password}, nil
+}
+
+// Refresh reads the password again. when it cannot be read the last password is kept.
+func (p *rotatingPassword) Refresh() bool {
+	password, err := p.read()
+	p.mtx.Lock()
+	p.readAt = time.Now()
+	if err != nil {
+		p.mtx.Unlock()
+		log.DefaultLogger.Warn("reading the rotated password failed, keeping the current password", "error", err.Error())
+		return false
+	}
+	changed := password != p.password
+	p.password = password
+	onChange := p.onChange
+	p.mtx.Unlock()
+	if changed {
+		log.DefaultLogger.Info("password changed, new connections use the new password")
+		if onChange != nil {
+			onChange()
+		}
+	}
+	return changed
+}
+
+// credentialProvider returns the provider of the credentials the data source user logs in with.
+func (config *datasourceConfig) credentialProvider(secureJSONData map[string]string, httpClient *http.Client) (credentialProvider, error) {
+	if config.AuthType == authTypeOAuth {
+		if config.OAuth.ForwardUserToken {
+			// connections are opened per grafana user, see connectionPool
+			return &forwardedToken{}, nil
+		}
+		return &clientCredentialsToken{
+			httpClient:   httpClient,
+			config:       config.OAuth,
+			clientSecret: secureJSONData[oauthClientSecretKey],
+		}, nil
+	}
+	if config.PasswordSource.File != "" || config.PasswordSource.Env != "" {
+		return newRotatingPassword(config.PasswordSource)
+	}
+	return staticCredentials{Password: secureJSONData["password"]}, nil
+}
+
+// connectionLifetime is the maximum lifetime of connections opened with a token valid for lifetime.
+func connectionLifetime(lifetime time.Duration) time.Duration {
+	if lifetime > 2*tokenRefreshMargin {
+		return lifetime - tokenRefreshMargin
+	}
+	return lifetime / 2
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPasswordFileDirectory(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "secrets")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{filepath.Join(dir, "vertica"): "secret\n", filepath.Join(root, "other"): "other"} {
+		if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "other"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	source := passwordSource{File: filepath.Join(dir, "vertica")}
+	t.Setenv(passwordDirEnv, "")
+	if _, err := newRotatingPassword(source); err == nil || !strings.Contains(err.Error(), passwordDirEnv) {
+		t.Errorf("reading a password file without %s returned %v, want an error", passwordDirEnv, err)
+	}
+	t.Setenv(passwordDirEnv, dir)
+	for _, file := range []string{filepath.Join(dir, "vertica"), "vertica"} {
+		p, err := newRotatingPassword(passwordSource{File: file})
+		if err != nil || p.password != "secret" {
+			t.Errorf("reading the password file %s failed: %v", file, err)
+		}
+	}
+	for _, file := range []string{filepath.Join(root, "other"), filepath.Join(dir, "..", "other"), "../other", "link"} {
+		if _, err := newRotatingPassword(passwordSource{File: file}); err == nil {
+			t.Errorf("the password file %s outside of the directory was read", file)
+		}
+	}
+}
+
+func TestPasswordEnvPrefix(t *testing.T) {
+	t.Setenv("VERTICA_PASSWORD_TEST", "secret")
+	t.Setenv("GF_SECURITY_SECRET_KEY_TEST", "grafana")
+	t.Setenv(passwordEnvPrefixEnv, "")
+	if _, err := newRotatingPassword(passwordSource{Env: "VERTICA_PASSWORD_TEST"}); err == nil {
+		t.Errorf("a password variable was read without %s", passwordEnvPrefixEnv)
+	}
+	t.Setenv(passwordEnvPrefixEnv, "VERTICA_PASSWORD_")
+	if p, err := newRotatingPassword(passwordSource{Env: "VERTICA_PASSWORD_TEST"}); err != nil || p.password != "secret" {
+		t.Errorf("reading the password variable failed: %v", err)
+	}
+	if _, err := newRotatingPassword(passwordSource{Env: "GF_SECURITY_SECRET_KEY_TEST"}); err == nil {
+		t.Error("a variable without the prefix was read")
+	}
+}
+
+func TestClientCredentialsTokenRefresh(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.PostForm.Get("grant_type") != "client_credentials" || r.PostForm.Get("client_id") != "grafana" ||
+			r.PostForm.Get("client_secret") != "s&cret" || r.PostForm.Get("scope") != "vertica read" {
+			t.Errorf("the token request has the form %v", r.PostForm)
+		}
+		fmt.Fprintf(w, `{"access_token":"token%d","expires_in":3600}`, n)
+	}))
+	defer server.Close()
+	var lifetimes []time.Duration
+	provider := &clientCredentialsToken{
+		httpClient:   server.Client(),
+		config:       oauthConfig{TokenURL: server.URL, ClientID: "grafana", Scopes: []string{"vertica", "read"}},
+		clientSecret: "s&cret",
+		onRefresh:    func(lifetime time.Duration) { lifetimes = append(lifetimes, lifetime) },
+	}
+	token := func() string {
+		creds, err := provider.Credentials(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return creds.AccessToken
+	}
+
+	if got := token(); got != "token1" || token() != "token1" {
+		t.Errorf("got the token %q, want token1 cached", got)
+	}
+	if provider.expiry.Before(time.Now().Add(59*time.Minute)) || len(lifetimes) != 1 || lifetimes[0] != time.Hour {
+		t.Errorf("the token expires at %v with the lifetimes %v, want in an hour", provider.expiry, lifetimes)
+	}
+	// a token expiring within the refresh margin is replaced
+	provider.expiry = time.Now().Add(tokenRefreshMargin - time.Second)
+	if got := token(); got != "token2" {
+		t.Errorf("got the token %q after it nearly expired, want token2", got)
+	}
+	// a token without lifetime does not expire
+	provider.expiry = time.Time{}
+	if got := token(); got != "token2" || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("got the token %q with %d requests, want token2 with 2", got, requests)
+	}
+}
+
+func TestClientCredentialsTokenErrors(t *testing.T) {
+	for response, want := range map[string]string{
+		"denied":            "401 Unauthorized: denied",
+		`{"expires_in":60}`: "no access_token",
+		`{"access_token":`:  "invalid token response",
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if response == "denied" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			w.Write([]byte(response))
+		}))
+		provider := &clientCredentialsToken{httpClient: server.Client(), config: oauthConfig{TokenURL: server.URL}}
+		_, err := provider.Credentials(context.Background())
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("the response %q returned %v, want %q", response, err, want)
+		}
+		if provider.token != "" || provider.pending != nil {
+			t.Errorf("the response %q left the token %q and a pending request %v", response, provider.token, provider.pending)
+		}
+		server.Close()
+	}
+}
+
+func TestClientCredentialsTokenSingleRequest(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+	}))
+	defer server.Close()
+	provider := &clientCredentialsToken{httpClient: server.Client(), config: oauthConfig{TokenURL: server.URL}}
+
+	// a caller giving up does not cancel the request the others wait for
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := provider.Credentials(ctx)
+		canceled <- err
+	}()
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("the canceled caller returned %v, want context.Canceled", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if creds, err := provider.Credentials(context.Background()); err != nil || creds.AccessToken != "token" {
+				t.Errorf("a waiting caller got %q, %v", creds.AccessToken, err)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("the token was requested %d times, want once", n)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// cursor defaults, the ttl is in seconds.
+const (
+	defaultCursorTTL     = 300
+	defaultCursorMaxOpen = 5
+	defaultCursorPage    = 1000
+	maxCursorPage        = 50000
+)
+
+// errCursorNotFound is returned for unknown, expired and closed cursors, and for cursors of other users.
+var errCursorNotFound = errors.New("cursor not found, it was read completely, closed or expired")
+
+// cursorConfig configures the server side cursors of the /cursor routes. every open cursor holds a connection.
+type cursorConfig struct {
+	// TTLSeconds closes cursors which were not read for this long
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// MaxOpen limits the open cursors of the data source
+	MaxOpen int `json:"maxOpen,omitempty"`
+}
+
+func (c cursorConfig) ttl() time.Duration {
+	if c.TTLSeconds <= 0 {
+		return defaultCursorTTL * time.Second
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+func (c cursorConfig) maxOpen() int {
+	if c.MaxOpen <= 0 {
+		return defaultCursorMaxOpen
+	}
+	return c.MaxOpen
+}
+
+// cursorRequest is the body of the /cursor routes: the query model and page size to open a cursor with,
+// or the id of an open cursor.
+type cursorRequest struct {
+	queryModel
+	Cursor   string `json:"cursor,omitempty"`
+	PageSize int    `json:"pageSize,omitempty"`
+}
+
+// cursorPage is a page of the result of a cursor.
+type cursorPage struct {
+	// Cursor is the id to read the next page with, empty when the result was read completely and the cursor is closed
+	Cursor  string          `json:"cursor,omitempty"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	// Offset is the position of the first row of the page in the result
+	Offset int  `json:"offset"`
+	Done   bool `json:"done"`
+}
+
+// resultCursor is a query result held open on its connection, read a page at a time.
+type resultCursor struct {
+	id string
+	// rc is the request which opened the cursor, the cursor belongs to its user
+	rc          requestContext
+	refID       string
+	pageSize    int
+	connection  *sql.Conn
+	rows        *sql.Rows
+	prepared    preparedQuery
+	cancel      context.CancelFunc
+	columns     []string
+	columnTypes []*sql.ColumnType
+	observation *queryObservation
+	timer       *time.Timer
+
+	// mtx serializes reading the pages and closing the cursor
+	mtx    sync.Mutex
+	closed bool
+}
+
+// handleCursorOpen runs a query and returns the first page of its result, the rest stays on a server side cursor.
+func (td *VerticaDatasource) handleCursorOpen(w http.ResponseWriter, r *http.Request) {
+	rc, instance, req, ok := td.cursorRequest(w, r)
+	if !ok {
+		return
+	}
+	qm := req.queryModel
+	// the cursor pages through the result itself
+	qm.Pagination = nil
+	cursor, status, err := instance.openCursor(rc, qm, req.PageSize)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	instance.writePage(w, cursor)
+}
+
+// handleCursorNext returns the next page of an open cursor.
+func (td *VerticaDatasource) handleCursorNext(w http.ResponseWriter, r *http.Request) {
+	rc, instance, req, ok := td.cursorRequest(w, r)
+	if !ok {
+		return
+	}
+	cursor := instance.findCursor(rc, req.Cursor)
+	if cursor == nil {
+		writeError(w, http.StatusNotFound, errCursorNotFound)
+		return
+	}
+	instance.writePage(w, cursor)
+}
+
+// handleCursorClose closes an open cursor before it is read completely.
+func (td *VerticaDatasource) handleCursorClose(w http.ResponseWriter, r *http.Request) {
+	rc, instance, req, ok := td.cursorRequest(w, r)
+	if !ok {
+		return
+	}
+	cursor := instance.findCursor(rc, req.Cursor)
+	if cursor == nil {
+		writeError(w, http.StatusNotFound, errCursorNotFound)
+		return
+	}
+	instance.closeCursor(cursor, nil)
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+// cursorRequest decodes the body of a cursor resource call, writing the error response when it fails.
+func (td *VerticaDatasource) cursorRequest(w http.ResponseWriter, r *http.Request) (requestContext, *instanceSettings, cursorRequest, bool) {
+	var req cursorRequest
+	rc, instance, err := td.resourceRequest(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return rc, nil, req, false
+	}
+	if !decodeRequest(w, r, &req) {
+		return rc, nil, req, false
+	}
+	return rc, instance, req, true
+}
+
+// openCursor runs the query on a connection held by a new cursor. the query is not bound to the request
+// which opened the cursor, it runs until the cursor is read completely, closed or expires.
+func (s *instanceSettings) openCursor(rc requestContext, qm queryModel, pageSize int) (*resultCursor, int, error) {
+	if pageSize <= 0 {
+		pageSize = defaultCursorPage
+	}
+	if pageSize > maxCursorPage {
+		return nil, http.StatusBadRequest, fmt.Errorf("page size %d is larger than %d", pageSize, maxCursorPage)
+	}
+	refID := qm.RefId
+	if refID == "" {
+		refID = "Cursor"
+	}
+	prepared, err := s.prepareQuery(rc, qm, refID)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if err := prepared.readOnly(); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	id, err := newCursorID()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	// the slot is reserved before the query runs, concurrent opens can not exceed the limit. closeCursor releases it
+	if err := s.reserveCursor(); err != nil {
+		return nil, http.StatusTooManyRequests, err
+	}
+	observation := observeQuery(s.UID, "Cursor")
+	observation.stage = errorClassQuery
+	observation.statement = prepared.statement
+	ctx, cancel := context.WithCancel(context.Background())
+	connection, rows, err := prepared.run(ctx, prepared.statement, &observation.timing)
+	if err != nil {
+		cancel()
+		s.releaseCursor()
+		observation.step = "queryContext"
+		observation.done(err)
+		s.logQuery(rc, refID, observation, err)
+		return nil, http.StatusBadRequest, err
+	}
+	cursor := &resultCursor{id: id, rc: rc, refID: refID, pageSize: pageSize, connection: connection,
+		rows: rows, prepared: prepared, cancel: cancel, observation: observation}
+	if cursor.columns, err = rows.Columns(); err == nil {
+		cursor.columnTypes, err = rows.ColumnTypes()
+	}
+	if err != nil {
+		s.closeCursor(cursor, err)
+		return nil, http.StatusInternalServerError, err
+	}
+	// the timer is set before the cursor can be found, reading a page resets it
+	cursor.timer = time.AfterFunc(s.config.Cursor.ttl(), func() {
+		log.DefaultLogger.Debug("cursor expired", append(s.logFields(rc, refID), "cursor", id)...)
+		s.closeCursor(cursor, nil)
+	})
+	s.cursorMtx.Lock()
+	if s.cursors == nil {
+		s.cursors = make(map[string]*resultCursor)
+	}
+	s.cursors[id] = cursor
+	s.cursorMtx.Unlock()
+	return cursor, http.StatusOK, nil
+}
+
+// reserveCursor takes one of the cursor.maxOpen slots of the open cursors, or fails when they are all taken.
+func (s *instanceSettings) reserveCursor() error {
+	s.cursorMtx.Lock()
+	defer s.cursorMtx.Unlock()
+	if s.cursorSlots >= s.config.Cursor.maxOpen() {
+		return fmt.Errorf("%d cursors are open, close one or wait until they expire", s.cursorSlots)
+	}
+	s.cursorSlots++
+	return nil
+}
+
+// releaseCursor frees the slot of a closed cursor, or of a cursor whose query failed.
+func (s *instanceSettings) releaseCursor() {
+	s.cursorMtx.Lock()
+	s.cursorSlots--
+	s.cursorMtx.Unlock()
+}
+
+// findCursor returns the open cursor with id, if it belongs to the user of the request.
+func (s *instanceSettings) findCursor(rc requestContext, id string) *resultCursor {
+	s.cursorMtx.Lock()
+	defer s.cursorMtx.Unlock()
+	cursor := s.cursors[id]
+	if cursor == nil || cursor.rc.userLogin() != rc.userLogin() {
+		return nil
+	}
+	return cursor
+}
+
+// writePage reads the next page of the cursor and writes it as the response, the cursor is closed after
+// the last page and when reading fails.
+func (s *instanceSettings) writePage(w http.ResponseWriter, cursor *resultCursor) {
+	cursor.mtx.Lock()
+	if cursor.closed {
+		cursor.mtx.Unlock()
+		writeError(w, http.StatusNotFound, errCursorNotFound)
+		return
+	}
+	cursor.timer.Reset(s.config.Cursor.ttl())
+	cursorPages.WithLabelValues(s.UID).Inc()
+	page := cursorPage{Cursor: cursor.id, Columns: cursor.columns, Rows: make([][]interface{}, 0), Offset: cursor.observation.rows}
+	var err error
+	fetchStart := time.Now()
+	for len(page.Rows) < cursor.pageSize {
+		if !cursor.rows.Next() {
+			page.Done = true
+			err = cursor.rows.Err()
+			break
+		}
+		// every row has its own scan targets, the values of the page point into them. NULL values are nil
+		rowIn := generateNullableRowIn(cursor.columnTypes)
+		if err = cursor.rows.Scan(rowIn...); err != nil {
+			cursor.observation.stage = errorClassConversion
+			break
+		}
+		values := make([]interface{}, len(rowIn))
+		scannedValues(rowIn, values)
+		cursor.observation.scanned(values)
+		page.Rows = append(page.Rows, values)
+	}
+	cursor.observation.timing.Fetch += time.Since(fetchStart)
+	cursor.mtx.Unlock()
+	if err != nil || page.Done {
+		page.Cursor = ""
+		s.closeCursor(cursor, err)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// closeCursor closes the result and releases the connection of the cursor, and records the query it ran.
+func (s *instanceSettings) closeCursor(cursor *resultCursor, err error) {
+	cursor.mtx.Lock()
+	defer cursor.mtx.Unlock()
+	if cursor.closed {
+		return
+	}
+	cursor.closed = true
+	s.cursorMtx.Lock()
+	delete(s.cursors, cursor.id)
+	s.cursorSlots--
+	s.cursorMtx.Unlock()
+	if cursor.timer != nil {
+		cursor.timer.Stop()
+	}
+	cursor.rows.Close()
+	cursor.cancel()
+	s.releasePrepared(cursor.connection, cursor.prepared)
+	if err != nil {
+		cursor.observation.step = "cursor"
+	}
+	cursor.observation.done(err)
+	s.logQuery(cursor.rc, cursor.refID, cursor.observation, err)
+}
+
+// closeCursors closes every open cursor, when the instance is disposed.
+func (s *instanceSettings) closeCursors() {
+	s.cursorMtx.Lock()
+	cursors := make([]*resultCursor, 0, len(s.cursors))
+	for _, cursor := range s.cursors {
+		cursors = append(cursors, cursor)
+	}
+	s.cursorMtx.Unlock()
+	for _, cursor := range cursors {
+		s.closeCursor(cursor, nil)
+	}
+}
+
+// newCursorID returns a random cursor id.
+func newCursorID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
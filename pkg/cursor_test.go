@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func cursorInstance(t *testing.T, maxOpen int, results ...fakeStatement) *instanceSettings {
+	db, _ := newFakeDB(results...)
+	t.Cleanup(func() { db.Close() })
+	instance := &instanceSettings{Db: db, UID: "test"}
+	instance.config.Cursor.MaxOpen = maxOpen
+	t.Cleanup(instance.closeCursors)
+	return instance
+}
+
+func TestOpenCursorLimit(t *testing.T) {
+	instance := cursorInstance(t, 2)
+	statement := "SELECT 1"
+	var wg sync.WaitGroup
+	statuses := make([]int, 10)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, statuses[i], _ = instance.openCursor(requestContext{}, queryModel{QueryString: statement, QueryTemplated: statement}, 10)
+		}(i)
+	}
+	wg.Wait()
+	opened := 0
+	for _, status := range statuses {
+		if status == http.StatusOK {
+			opened++
+		} else if status != http.StatusTooManyRequests {
+			t.Errorf("opening a cursor answered %d", status)
+		}
+	}
+	if opened != 2 {
+		t.Errorf("%d cursors were opened concurrently, want the limit of 2", opened)
+	}
+
+	instance.closeCursors()
+	if _, status, err := instance.openCursor(requestContext{}, queryModel{QueryString: statement, QueryTemplated: statement}, 10); err != nil {
+		t.Errorf("opening a cursor after closing the others failed with %d: %v", status, err)
+	}
+}
+
+func TestOpenCursorReleasesFailedSlot(t *testing.T) {
+	instance := cursorInstance(t, 1, fakeStatement{match: "broken", result: fakeResult{err: errors.New("relation broken does not exist")}})
+	broken := "SELECT broken"
+	if _, _, err := instance.openCursor(requestContext{}, queryModel{QueryString: broken, QueryTemplated: broken}, 10); err == nil {
+		t.Fatal("the broken query opened a cursor")
+	}
+	statement := "SELECT 1"
+	if _, status, err := instance.openCursor(requestContext{}, queryModel{QueryString: statement, QueryTemplated: statement}, 10); err != nil {
+		t.Errorf("the failed query kept its slot, opening a cursor failed with %d: %v", status, err)
+	}
+}
+
+func TestCursorPageNulls(t *testing.T) {
+	instance := cursorInstance(t, 1, fakeStatement{match: "FROM metrics", result: exportResult})
+	statement := "SELECT time, value, host FROM metrics"
+	cursor, _, err := instance.openCursor(requestContext{}, queryModel{QueryString: statement, QueryTemplated: statement}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	instance.writePage(recorder, cursor)
+	want := `{"columns":["time","value","host"],"rows":[["2021-06-01T00:00:00Z",1.5,"a"],[null,null,null],["2021-06-01T00:01:00Z",2.5,null]],"offset":0,"done":true}`
+	if recorder.Code != http.StatusOK || strings.TrimSuffix(recorder.Body.String(), "\n") != want {
+		t.Errorf("the page is %d %s\nwant %s", recorder.Code, recorder.Body.String(), want)
+	}
+}
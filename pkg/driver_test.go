@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeResult is the result the fake driver returns for a statement, the types are vertica type names.
+type fakeResult struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+	err     error
+}
+
+// fakeConnector is a driver connector answering statements with the result of the first matching entry of results,
+// an empty result for the others. the statements it ran are recorded.
+type fakeConnector struct {
+	mtx        sync.Mutex
+	results    []fakeStatement
+	statements []string
+}
+
+// fakeStatement answers statements containing match with result, times times or always when times is 0.
+type fakeStatement struct {
+	match  string
+	result fakeResult
+	times  int
+}
+
+// newFakeDB returns a database answering with results.
+func newFakeDB(results ...fakeStatement) (*sql.DB, *fakeConnector) {
+	connector := &fakeConnector{results: results}
+	return sql.OpenDB(connector), connector
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{connector: c}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+// result returns the result of query, using up the answers given a limited number of times.
+func (c *fakeConnector) result(query string) fakeResult {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.statements = append(c.statements, query)
+	for i := range c.results {
+		statement := &c.results[i]
+		if !strings.Contains(query, statement.match) || statement.times < 0 {
+			continue
+		}
+		if statement.times > 0 {
+			statement.times--
+			if statement.times == 0 {
+				statement.times = -1
+			}
+		}
+		return statement.result
+	}
+	return fakeResult{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("open the fake driver with a connector")
+}
+
+type fakeConn struct {
+	connector *fakeConnector
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("the fake driver does not prepare statements")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("the fake driver has no transactions")
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.connector.result(query)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &fakeRows{result: result}, nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	row    int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.row >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.row])
+	r.row++
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.result.types[index]
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// explainResult is the response of the /explain route.
+type explainResult struct {
+	// Plan are the root operators of the access path
+	Plan []*planNode `json:"plan"`
+	// Text is the plan as printed by vertica
+	Text []string `json:"text"`
+	// Profiled is set when the query was run with PROFILE and the nodes carry their execution profile
+	Profiled bool `json:"profiled"`
+}
+
+// planNode is an operator of the access path of a query.
+type planNode struct {
+	// PathID links the operator to the vertica profiling tables, -1 when the plan does not show it
+	PathID   int     `json:"pathId"`
+	Operator string  `json:"operator"`
+	Cost     float64 `json:"cost"`
+	Rows     float64 `json:"rows"`
+	// Projections are the projections read by a storage access
+	Projections []string     `json:"projections,omitempty"`
+	Details     []string     `json:"details,omitempty"`
+	Profile     *pathProfile `json:"profile,omitempty"`
+	Children    []*planNode  `json:"children,omitempty"`
+
+	// depth is the column of the operator marker in the plan text
+	depth int
+}
+
+// pathProfile is the execution profile of a plan path.
+type pathProfile struct {
+	RunningTime          string `json:"runningTime"`
+	MemoryAllocatedBytes int64  `json:"memoryAllocatedBytes"`
+	ReadFromDiskBytes    int64  `json:"readFromDiskBytes"`
+	ReceivedBytes        int64  `json:"receivedBytes"`
+	SentBytes            int64  `json:"sentBytes"`
+}
+
+// pathProfileQuery reads the execution profile of every path of a statement.
+const pathProfileQuery = `SELECT path_id, COALESCE(MAX(running_time)::VARCHAR, ''), COALESCE(MAX(memory_allocated_bytes), 0),
+COALESCE(MAX(read_from_disk_bytes), 0), COALESCE(MAX(received_bytes), 0), COALESCE(MAX(sent_bytes), 0)
+FROM v_monitor.query_plan_profiles WHERE transaction_id = ? AND statement_id = ? GROUP BY path_id`
+
+// planOperator matches an operator line of an EXPLAIN plan, e.g.
+// | +---> STORAGE ACCESS for t [Cost: 10, Rows: 10K (NO STATISTICS)] (PATH ID: 2)
+var planOperator = regexp.MustCompile(`^([\s|]*)(\+-+>?\s*)?(.*?)\s*\[Cost:\s*([^,\]]+),\s*Rows:\s*([^\]\s]+)[^\]]*\](?:\s*\(PATH ID:\s*(\d+)\))?(.*)$`)
+
+// planDetail matches a property line below an operator, e.g. |      Projection: public.t_super
+var planDetail = regexp.MustCompile(`^[\s|]*([A-Za-z][A-Za-z ]*):\s*(.*)$`)
+
+// handleExplain returns the plan of a query as a tree of operators. with ?profile=true the query is also run
+// with PROFILE and the operators carry their execution profile, when the data source allows it.
+func (td *VerticaDatasource) handleExplain(w http.ResponseWriter, r *http.Request) {
+	rc, instance, err := td.resourceRequest(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var qm queryModel
+	if !decodeRequest(w, r, &qm) {
+		return
+	}
+	profile := r.URL.Query().Get("profile") == "true"
+	if profile && !instance.config.AllowProfile {
+		writeError(w, http.StatusForbidden, errors.New("PROFILE runs the query, it has to be allowed with allowProfile in the data source settings"))
+		return
+	}
+	result, err := instance.explain(r.Context(), rc, qm, profile)
+	if err != nil {
+		step := failedStep(err, "explain")
+		log.DefaultLogger.Warn("explain failed", append(instance.logFields(rc, qm.RefId), "step", step, "error", err.Error())...)
+		status := http.StatusBadRequest
+		if step == "readOnly" {
+			status = http.StatusForbidden
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// explain runs EXPLAIN for the statement of a query model, prepared like a panel query, in a read only session.
+// only single statements are explained, the statements before the last one of a query would run to explain it.
+func (s *instanceSettings) explain(ctx context.Context, rc requestContext, qm queryModel, profile bool) (*explainResult, error) {
+	prepared, err := s.prepareQuery(rc, qm, qm.RefId)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepared.readOnly(); err != nil {
+		return nil, &stepError{step: "readOnly", err: err}
+	}
+	start := time.Now()
+	statement := "EXPLAIN " + prepared.statement
+	connection, rows, err := prepared.run(ctx, statement, &queryTiming{})
+	if err != nil {
+		s.audit(rc, qm.RefId, statement, err, errorClassQuery, time.Since(start), 0)
+		return nil, err
+	}
+	defer s.releasePrepared(connection, prepared)
+	text, err := readPlanText(rows)
+	s.audit(rc, qm.RefId, statement, err, errorClassQuery, time.Since(start), len(text))
+	if err != nil {
+		return nil, err
+	}
+	result := &explainResult{Plan: parsePlan(text), Text: text}
+	if !profile {
+		return result, nil
+	}
+	profiles, err := s.profile(ctx, rc, qm.RefId, connection, prepared.statement, prepared.args)
+	if err != nil {
+		return nil, err
+	}
+	result.Profiled = true
+	walkPlan(result.Plan, func(node *planNode) {
+		node.Profile = profiles[node.PathID]
+	})
+	return result, nil
+}
+
+// profile runs statement with PROFILE on the connection and returns the execution profile of its paths.
+func (s *instanceSettings) profile(ctx context.Context, rc requestContext, refID string, connection *sql.Conn, statement string, args []interface{}) (map[int]*pathProfile, error) {
+	start := time.Now()
+	statement = "PROFILE " + statement
+	rows, err := connection.QueryContext(ctx, statement, args...)
+	count := 0
+	if err == nil {
+		// the result of the query is not needed, only its profile
+		for rows.Next() {
+			count++
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	s.audit(rc, refID, statement, err, errorClassQuery, time.Since(start), count)
+	if err != nil {
+		return nil, err
+	}
+	transactionID, statementID, err := lastStatementIDs(ctx, connection)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = connection.QueryContext(ctx, pathProfileQuery, transactionID, statementID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	profiles := make(map[int]*pathProfile)
+	for rows.Next() {
+		var pathID int
+		p := &pathProfile{}
+		if err := rows.Scan(&pathID, &p.RunningTime, &p.MemoryAllocatedBytes, &p.ReadFromDiskBytes, &p.ReceivedBytes, &p.SentBytes); err != nil {
+			return nil, err
+		}
+		profiles[pathID] = p
+	}
+	return profiles, rows.Err()
+}
+
+// readPlanText returns the lines of an EXPLAIN result, a row may hold several lines.
+func readPlanText(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	text := make([]string, 0)
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return text, err
+		}
+		text = append(text, strings.Split(strings.TrimRight(line, "\n"), "\n")...)
+	}
+	return text, rows.Err()
+}
+
+// parsePlan builds the operator tree of the access path of an EXPLAIN plan. operators are nested by the
+// column of their +- marker, the property lines below an operator are added to it.
+func parsePlan(text []string) []*planNode {
+	roots := make([]*planNode, 0)
+	var stack []*planNode
+	// the access path follows a header which is underlined like the graphviz section
+	for i, line := range text {
+		if strings.HasPrefix(strings.TrimSpace(line), "Access Path:") {
+			text = text[i+1:]
+			break
+		}
+	}
+	for _, line := range text {
+		trimmed := strings.TrimSpace(line)
+		// the access path is followed by the plan in graphviz format, which repeats it
+		if strings.HasPrefix(trimmed, "-----") || strings.Contains(trimmed, "GraphViz Format") {
+			break
+		}
+		if match := planOperator.FindStringSubmatch(line); match != nil {
+			node := &planNode{
+				PathID:   -1,
+				Operator: strings.TrimSpace(match[3] + " " + strings.TrimSpace(match[7])),
+				Cost:     parseEstimate(match[4]),
+				Rows:     parseEstimate(match[5]),
+				depth:    len(match[1]),
+			}
+			if match[6] != "" {
+				node.PathID, _ = strconv.Atoi(match[6])
+			}
+			for len(stack) > 0 && stack[len(stack)-1].depth >= node.depth {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				roots = append(roots, node)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, node)
+			}
+			stack = append(stack, node)
+			continue
+		}
+		if len(stack) == 0 {
+			continue
+		}
+		node := stack[len(stack)-1]
+		if match := planDetail.FindStringSubmatch(line); match != nil && match[1] == "Projection" {
+			node.Projections = append(node.Projections, strings.TrimSpace(match[2]))
+		} else if detail := strings.TrimLeft(trimmed, "| "); detail != "" {
+			node.Details = append(node.Details, detail)
+		}
+	}
+	return roots
+}
+
+// parseEstimate parses a cost or row estimate of a plan, which vertica abbreviates as 10K, 2M and so on.
+func parseEstimate(estimate string) float64 {
+	estimate = strings.TrimSpace(estimate)
+	multiplier := 1.0
+	if n := len(estimate); n > 0 {
+		switch estimate[n-1] {
+		case 'K':
+			multiplier = 1e3
+		case 'M':
+			multiplier = 1e6
+		case 'G', 'B':
+			multiplier = 1e9
+		case 'T':
+			multiplier = 1e12
+		case 'P':
+			multiplier = 1e15
+		}
+		if multiplier != 1 {
+			estimate = estimate[:n-1]
+		}
+	}
+	value, err := strconv.ParseFloat(estimate, 64)
+	if err != nil {
+		return 0
+	}
+	return value * multiplier
+}
+
+// walkPlan calls fn for every node of the plan.
+func walkPlan(nodes []*planNode, fn func(*planNode)) {
+	for _, node := range nodes {
+		fn(node)
+		walkPlan(node.Children, fn)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hashJoinPlan is the EXPLAIN output of a query joining two tables with a hash join, as printed by vertica.
+const hashJoinPlan = ` ------------------------------
+ QUERY PLAN DESCRIPTION:
+ ------------------------------
+
+ EXPLAIN SELECT c.customer_name, SUM(s.sales_dollar_amount) FROM store.store_sales_fact s JOIN public.customer_dimension c ON c.customer_key = s.customer_key GROUP BY 1 ORDER BY 2 DESC LIMIT 10;
+
+ Access Path:
+ +-SELECT  LIMIT 10 [Cost: 3K, Rows: 10 (NO STATISTICS)] (PATH ID: 0)
+ |  Output Only: 10 tuples
+ |  Execute on: Query Initiator
+ | +---> SORT [TOPK] [Cost: 3K, Rows: 10K (NO STATISTICS)] (PATH ID: 1)
+ | |      Order: SUM(s.sales_dollar_amount) DESC
+ | |      Output Only: 10 tuples
+ | |      Execute on: All Nodes
+ | | +---> GROUPBY HASH (LOCAL RESEGMENT GROUPS) [Cost: 2.5K, Rows: 10K (NO STATISTICS)] (PATH ID: 2)
+ | | |      Aggregates: sum(s.sales_dollar_amount)
+ | | |      Group By: c.customer_name
+ | | |      Execute on: All Nodes
+ | | | +---> JOIN HASH [Cost: 1.2K, Rows: 5M (NO STATISTICS)] (PATH ID: 3) Outer (BROADCAST)(LOCAL ROUND ROBIN)
+ | | | |      Join Cond: (c.customer_key = s.customer_key)
+ | | | |      Execute on: All Nodes
+ | | | | +-- Outer -> STORAGE ACCESS for s [Cost: 708, Rows: 5M (NO STATISTICS)] (PATH ID: 4)
+ | | | | |      Projection: store.store_sales_fact_super
+ | | | | |      Materialize: s.customer_key, s.sales_dollar_amount
+ | | | | |      Execute on: All Nodes
+ | | | | |      Runtime Filter: (SIP1(HashJoin): s.customer_key)
+ | | | | +-- Inner -> STORAGE ACCESS for c [Cost: 64, Rows: 50K (NO STATISTICS)] (PATH ID: 5)
+ | | | | |      Projection: public.customer_dimension_super
+ | | | | |      Materialize: c.customer_key, c.customer_name
+ | | | | |      Execute on: All Nodes
+
+
+ -----------------------------------------------
+ PLAN: BASE QUERY PLAN (GraphViz Format)
+ -----------------------------------------------
+ digraph G {
+ graph [rankdir=BT, label = "BASE QUERY PLAN\nQuery: EXPLAIN SELECT", labelloc=t, labeljust=l ordering=out]
+ 0[label = "Root \nOutBlk=[UncTuple]", color = "green", shape = "house"];
+ }`
+
+// mergeJoinPlan is the access path of a merge join of two sorted projections, the plan text without its header.
+const mergeJoinPlan = ` Access Path:
+ +-JOIN MERGEJOIN(inputs presorted) [Cost: 1M, Rows: 1B] (PATH ID: 1)
+ |  Join Cond: (a.id = b.id)
+ |  Execute on: All Nodes
+ | +-- Outer -> STORAGE ACCESS for a [Cost: 120, Rows: 1B] (PATH ID: 2)
+ | |      Projection: public.a_by_id
+ | |      Execute on: All Nodes
+ | +-- Inner -> STORAGE ACCESS for b [Cost: 95.5, Rows: 800M] (PATH ID: 3)
+ | |      Projection: public.b_by_id
+ | |      Execute on: All Nodes`
+
+// planShape returns the operators of the plan with their depth in the tree, one line each.
+func planShape(nodes []*planNode, depth int) []string {
+	lines := make([]string, 0)
+	for _, node := range nodes {
+		lines = append(lines, strings.Repeat("  ", depth)+node.Operator)
+		lines = append(lines, planShape(node.Children, depth+1)...)
+	}
+	return lines
+}
+
+func TestParsePlanHashJoin(t *testing.T) {
+	plan := parsePlan(strings.Split(hashJoinPlan, "\n"))
+	want := []string{
+		"SELECT  LIMIT 10",
+		"  SORT [TOPK]",
+		"    GROUPBY HASH (LOCAL RESEGMENT GROUPS)",
+		"      JOIN HASH Outer (BROADCAST)(LOCAL ROUND ROBIN)",
+		"        Outer -> STORAGE ACCESS for s",
+		"        Inner -> STORAGE ACCESS for c",
+	}
+	if got := planShape(plan, 0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("the plan is\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	root := plan[0]
+	if root.PathID != 0 || root.Cost != 3000 || root.Rows != 10 {
+		t.Errorf("the root has path %d, cost %v and rows %v, want 0, 3000 and 10", root.PathID, root.Cost, root.Rows)
+	}
+	if want := []string{"Output Only: 10 tuples", "Execute on: Query Initiator"}; !reflect.DeepEqual(root.Details, want) {
+		t.Errorf("the root has the details %q, want %q", root.Details, want)
+	}
+	groupBy := root.Children[0].Children[0]
+	if groupBy.Cost != 2500 || groupBy.Rows != 10000 {
+		t.Errorf("the group by has cost %v and rows %v, want 2500 and 10000", groupBy.Cost, groupBy.Rows)
+	}
+	join := groupBy.Children[0]
+	if join.PathID != 3 || join.Rows != 5e6 || join.Details[0] != "Join Cond: (c.customer_key = s.customer_key)" {
+		t.Errorf("the join is %+v", join)
+	}
+	outer, inner := join.Children[0], join.Children[1]
+	if outer.PathID != 4 || !reflect.DeepEqual(outer.Projections, []string{"store.store_sales_fact_super"}) || len(outer.Details) != 3 {
+		t.Errorf("the outer input is %+v", outer)
+	}
+	if inner.PathID != 5 || inner.Rows != 50000 || !reflect.DeepEqual(inner.Projections, []string{"public.customer_dimension_super"}) {
+		t.Errorf("the inner input is %+v", inner)
+	}
+}
+
+func TestParsePlanMergeJoin(t *testing.T) {
+	plan := parsePlan(strings.Split(mergeJoinPlan, "\n"))
+	want := []string{
+		"JOIN MERGEJOIN(inputs presorted)",
+		"  Outer -> STORAGE ACCESS for a",
+		"  Inner -> STORAGE ACCESS for b",
+	}
+	if got := planShape(plan, 0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("the plan is\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if plan[0].Cost != 1e6 || plan[0].Rows != 1e9 {
+		t.Errorf("the join has cost %v and rows %v, want 1e6 and 1e9", plan[0].Cost, plan[0].Rows)
+	}
+	if inner := plan[0].Children[1]; inner.Cost != 95.5 || inner.Rows != 800e6 {
+		t.Errorf("the inner input has cost %v and rows %v, want 95.5 and 800e6", inner.Cost, inner.Rows)
+	}
+}
+
+func TestParsePlanUnmatchedLines(t *testing.T) {
+	// lines before the first operator and lines which are not operators are no nodes
+	plan := parsePlan([]string{
+		"NOTICE: the statistics of t are stale",
+		"+-SELECT [Cost: ?, Rows: unknown]",
+		"| +---> STORAGE ACCESS for t [Cost: 12]",
+		"|  some note",
+	})
+	if len(plan) != 1 || plan[0].Cost != 0 || plan[0].Rows != 0 || plan[0].PathID != -1 {
+		t.Fatalf("the plan is %+v, want a root without estimates and path id", plan)
+	}
+	if len(plan[0].Children) != 0 || !reflect.DeepEqual(plan[0].Details, []string{"+---> STORAGE ACCESS for t [Cost: 12]", "some note"}) {
+		t.Errorf("the root has the children %v and the details %q", plan[0].Children, plan[0].Details)
+	}
+	if plan := parsePlan(nil); len(plan) != 0 {
+		t.Errorf("an empty plan has the nodes %v", plan)
+	}
+}
+
+func TestParseEstimate(t *testing.T) {
+	for estimate, want := range map[string]float64{
+		"10":    10,
+		"2.5K":  2500,
+		"3M":    3e6,
+		"1B":    1e9,
+		"1G":    1e9,
+		" 4T ":  4e12,
+		"":      0,
+		"?":     0,
+		"lots":  0,
+		"1.5KB": 0,
+	} {
+		if got := parseEstimate(estimate); got != want {
+			t.Errorf("parseEstimate(%q) = %v, want %v", estimate, got, want)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	goparquet "github.com/fraugster/parquet-go"
+	"github.com/fraugster/parquet-go/parquet"
+	"github.com/fraugster/parquet-go/parquetschema"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// formats of the /export route.
+const (
+	exportFormatCSV     = "csv"
+	exportFormatArrow   = "arrow"
+	exportFormatParquet = "parquet"
+)
+
+// defaultExportMaxRows limits exports when the data source does not configure a limit.
+const defaultExportMaxRows = 1000000
+
+// parquetRowGroupRows is the number of rows of a parquet row group.
+const parquetRowGroupRows = 10 * exportBatchRows
+
+// exportBatchRows is the number of rows of an arrow record batch, and of the csv rows written at once.
+const exportBatchRows = 10000
+
+// exportConfig configures the /export route.
+type exportConfig struct {
+	// MaxRows limits the rows of an export, the export stops after them
+	MaxRows int `json:"maxRows,omitempty"`
+}
+
+// maxRows returns the configured row limit, or the default.
+func (c exportConfig) maxRows() int {
+	if c.MaxRows <= 0 {
+		return defaultExportMaxRows
+	}
+	return c.MaxRows
+}
+
+// exportRequest is the body of the /export route, the query model of a panel query with the time range of the dashboard.
+type exportRequest struct {
+	queryModel
+	// Range is the time range of the dashboard in epoch milliseconds, used by builder queries
+	Range exportRange `json:"range"`
+	// MaxRows lowers the row limit of the data source for a single export
+	MaxRows int `json:"maxRows,omitempty"`
+}
+
+// exportRange is a time range in epoch milliseconds.
+type exportRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// exportWriter writes the rows of an export in its format, NULL values are nil. truncated tells the formats which
+// can record it in the file that the export stopped at the row limit.
+type exportWriter interface {
+	append(row []interface{}) error
+	close(truncated bool) error
+}
+
+// exportTruncatedHeader is the http header telling whether the export stopped at the row limit.
+const exportTruncatedHeader = "X-Export-Truncated"
+
+// handleExport runs a query model and returns its complete result as csv, as an arrow ipc stream or as a parquet
+// file, chosen with ?format=csv|arrow|parquet. the rows are written to the export as they are read and not kept in a frame.
+func (td *VerticaDatasource) handleExport(w http.ResponseWriter, r *http.Request) {
+	rc, instance, err := td.resourceRequest(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	switch format {
+	case "":
+		format = exportFormatCSV
+	case exportFormatCSV, exportFormatArrow, exportFormatParquet:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("export format %q is not supported, use csv, arrow or parquet", format))
+		return
+	}
+	var req exportRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	qm := req.queryModel
+	// the export is the complete result, not the page of the panel
+	qm.Pagination = nil
+	if req.Range.From > 0 && req.Range.To > 0 {
+		qm.From = time.Unix(0, req.Range.From*int64(time.Millisecond))
+		qm.To = time.Unix(0, req.Range.To*int64(time.Millisecond))
+	}
+	maxRows := instance.config.Export.maxRows()
+	if req.MaxRows > 0 && req.MaxRows < maxRows {
+		maxRows = req.MaxRows
+	}
+	instance.export(r.Context(), rc, w, qm, format, maxRows)
+}
+
+// export runs the query and writes its rows to w, at most maxRows of them. the export is written when the rows
+// are read, failed exports are answered with the error as json.
+func (s *instanceSettings) export(ctx context.Context, rc requestContext, w http.ResponseWriter, qm queryModel, format string, maxRows int) {
+	refID := qm.RefId
+	if refID == "" {
+		refID = "Export"
+	}
+	var err error
+	observation := observeQuery(s.UID, "Export")
+	ctx, span := tracer.Start(ctx, "export", trace.WithAttributes(
+		attribute.String("refId", refID),
+		attribute.String("exportFormat", format),
+	))
+	defer func() {
+		observation.done(err)
+		s.logQuery(rc, refID, observation, err)
+		span.SetAttributes(attribute.Int("rows", observation.rows))
+		endSpan(span, err)
+	}()
+	// fail records the failed step and returns the error
+	fail := func(step string, status int) {
+		observation.step = step
+		writeError(w, status, err)
+	}
+
+	prepared, err := s.prepareQuery(rc, qm, refID)
+	if err != nil {
+		fail(failedStep(err, "prepareQuery"), http.StatusBadRequest)
+		return
+	}
+	if err = prepared.readOnly(); err != nil {
+		fail("readOnly", http.StatusForbidden)
+		return
+	}
+	observation.stage = errorClassQuery
+	observation.statement = prepared.statement
+	connection, rows, err := prepared.run(ctx, prepared.statement, &observation.timing)
+	if err != nil {
+		fail("queryContext", http.StatusBadRequest)
+		return
+	}
+	defer s.releasePrepared(connection, prepared)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		fail("columns", http.StatusInternalServerError)
+		return
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		fail("columnTypes", http.StatusInternalServerError)
+		return
+	}
+
+	// the scan buffer is reused for every row, the writers copy the values out of it. NULL values are nil in values
+	rowIn := generateNullableRowIn(columnTypes)
+	values := make([]interface{}, len(rowIn))
+	// the export is buffered, whether it stops at the row limit is only known after the last row and is sent as
+	// a header. maxRows bounds the buffer
+	var buffer bytes.Buffer
+	var writer exportWriter
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case exportFormatArrow:
+		contentType = "application/vnd.apache.arrow.stream"
+		writer = newArrowExport(&buffer, columns, rowIn)
+	case exportFormatParquet:
+		contentType = "application/vnd.apache.parquet"
+		if writer, err = newParquetExport(&buffer, columns, rowIn); err != nil {
+			fail("schema", http.StatusInternalServerError)
+			return
+		}
+	default:
+		if writer, err = newCSVExport(&buffer, columns); err != nil {
+			fail("write", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	fetchStart := time.Now()
+	truncated := false
+	for rows.Next() {
+		if observation.rows >= maxRows {
+			truncated = true
+			break
+		}
+		if err = rows.Scan(rowIn...); err != nil {
+			observation.stage = errorClassConversion
+			fail("row.Scan", http.StatusBadRequest)
+			return
+		}
+		scannedValues(rowIn, values)
+		observation.scanned(values)
+		if err = writer.append(values); err != nil {
+			fail("write", http.StatusInternalServerError)
+			return
+		}
+	}
+	observation.timing.Fetch = time.Since(fetchStart)
+	if !truncated {
+		if err = rows.Err(); err != nil {
+			fail("rows.Err", http.StatusBadRequest)
+			return
+		}
+	}
+	if err = writer.close(truncated); err != nil {
+		fail("write", http.StatusInternalServerError)
+		return
+	}
+	if truncated {
+		log.DefaultLogger.Warn("export stopped at the row limit", append(s.logFields(rc, refID), "maxRows", maxRows)...)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export.%s"`, format))
+	w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	w.Header().Set("X-Export-Max-Rows", strconv.Itoa(maxRows))
+	w.Header().Set(exportTruncatedHeader, strconv.FormatBool(truncated))
+	w.WriteHeader(http.StatusOK)
+	if _, err = buffer.WriteTo(w); err != nil {
+		observation.step = "write"
+	}
+}
+
+// csvExport writes the rows of an export as csv with a header line of the column names.
+type csvExport struct {
+	writer *csv.Writer
+	record []string
+	rows   int
+}
+
+// newCSVExport writes the header line and returns the csv writer of the rows.
+func newCSVExport(w io.Writer, columns []string) (*csvExport, error) {
+	e := &csvExport{writer: csv.NewWriter(w), record: make([]string, len(columns))}
+	return e, e.writer.Write(columns)
+}
+
+func (e *csvExport) append(row []interface{}) error {
+	for i, value := range row {
+		e.record[i] = valueString(value)
+	}
+	if err := e.writer.Write(e.record); err != nil {
+		return err
+	}
+	e.rows++
+	if e.rows%exportBatchRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *csvExport) close(bool) error {
+	return e.flush()
+}
+
+// flush writes the buffered rows of the csv writer.
+func (e *csvExport) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// arrowExport writes the rows of an export as an arrow ipc stream of record batches.
+type arrowExport struct {
+	writer  *ipc.Writer
+	builder *array.RecordBuilder
+	rows    int
+}
+
+// newArrowExport returns the arrow writer of rows scanned into rowIn, the schema has the types of the scan targets.
+func newArrowExport(w io.Writer, columns []string, rowIn []interface{}) *arrowExport {
+	fields := make([]arrow.Field, len(columns))
+	for i, column := range columns {
+		fields[i] = arrow.Field{Name: column, Type: arrowType(rowIn[i]), Nullable: true}
+	}
+	schema := arrow.NewSchema(fields, nil)
+	mem := memory.NewGoAllocator()
+	return &arrowExport{
+		writer:  ipc.NewWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(mem)),
+		builder: array.NewRecordBuilder(mem, schema),
+	}
+}
+
+// arrowType returns the arrow type of a scan target of generateNullableRowIn, values without one are written as strings.
+func arrowType(value interface{}) arrow.DataType {
+	switch value.(type) {
+	case *sql.NullBool:
+		return arrow.FixedWidthTypes.Boolean
+	case *sql.NullInt64:
+		return arrow.PrimitiveTypes.Int64
+	case *sql.NullFloat64:
+		return arrow.PrimitiveTypes.Float64
+	case *sql.NullTime:
+		return arrow.FixedWidthTypes.Timestamp_ns
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+func (e *arrowExport) append(row []interface{}) error {
+	for i, value := range row {
+		if value == nil {
+			e.builder.Field(i).AppendNull()
+			continue
+		}
+		switch b := e.builder.Field(i).(type) {
+		case *array.BooleanBuilder:
+			b.Append(*value.(*bool))
+		case *array.Int64Builder:
+			b.Append(*value.(*int64))
+		case *array.Float64Builder:
+			b.Append(*value.(*float64))
+		case *array.TimestampBuilder:
+			b.Append(arrow.Timestamp(value.(*time.Time).UnixNano()))
+		case *array.StringBuilder:
+			b.Append(valueString(value))
+		}
+	}
+	e.rows++
+	if e.rows == exportBatchRows {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *arrowExport) close(bool) error {
+	defer e.builder.Release()
+	if e.rows > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	return e.writer.Close()
+}
+
+// flush writes the built rows as a record batch.
+func (e *arrowExport) flush() error {
+	record := e.builder.NewRecord()
+	defer record.Release()
+	e.rows = 0
+	return e.writer.Write(record)
+}
+
+// parquetExport writes the rows of an export as a parquet file of optional columns, NULL values are left out of
+// the row. the footer of the file is written when the export is closed, with the key export.truncated in its
+// metadata when the export stopped at the row limit.
+type parquetExport struct {
+	writer   *goparquet.FileWriter
+	columns  []string
+	row      map[string]interface{}
+	metadata map[string]string
+	rows     int
+}
+
+// newParquetExport returns the parquet writer of rows scanned into rowIn, the schema has the types of the scan
+// targets. parquet needs unique column names, repeated names get a suffix of their position.
+func newParquetExport(w io.Writer, columns []string, rowIn []interface{}) (*parquetExport, error) {
+	names := make([]string, len(columns))
+	seen := make(map[string]bool, len(columns))
+	root := &parquetschema.ColumnDefinition{SchemaElement: &parquet.SchemaElement{Name: "export"}}
+	for i, column := range columns {
+		name := column
+		if name == "" || seen[name] {
+			name = fmt.Sprintf("%s_%d", column, i+1)
+		}
+		seen[name] = true
+		names[i] = name
+		element := parquetElement(rowIn[i])
+		element.Name = name
+		root.Children = append(root.Children, &parquetschema.ColumnDefinition{SchemaElement: element})
+	}
+	schema := parquetschema.SchemaDefinitionFromColumnDefinition(root)
+	if err := schema.ValidateStrict(); err != nil {
+		return nil, fmt.Errorf("invalid parquet schema: %w", err)
+	}
+	// the writer keeps the metadata map and writes it into the footer on close
+	metadata := make(map[string]string)
+	return &parquetExport{
+		writer: goparquet.NewFileWriter(w,
+			goparquet.WithSchemaDefinition(schema),
+			goparquet.WithCompressionCodec(parquet.CompressionCodec_SNAPPY),
+			goparquet.WithCreator("vertica-datasource"),
+			goparquet.WithMetaData(metadata),
+		),
+		columns:  names,
+		row:      make(map[string]interface{}, len(columns)),
+		metadata: metadata,
+	}, nil
+}
+
+// parquetElement returns the optional parquet column of a scan target of generateNullableRowIn, values without
+// one are written as strings. times are microseconds in UTC.
+func parquetElement(value interface{}) *parquet.SchemaElement {
+	optional := parquet.FieldRepetitionType_OPTIONAL
+	element := &parquet.SchemaElement{RepetitionType: &optional}
+	physical := func(t parquet.Type) *parquet.Type { return &t }
+	converted := func(t parquet.ConvertedType) *parquet.ConvertedType { return &t }
+	switch value.(type) {
+	case *sql.NullBool:
+		element.Type = physical(parquet.Type_BOOLEAN)
+	case *sql.NullInt64:
+		element.Type = physical(parquet.Type_INT64)
+	case *sql.NullFloat64:
+		element.Type = physical(parquet.Type_DOUBLE)
+	case *sql.NullTime:
+		element.Type = physical(parquet.Type_INT64)
+		element.ConvertedType = converted(parquet.ConvertedType_TIMESTAMP_MICROS)
+		element.LogicalType = &parquet.LogicalType{TIMESTAMP: &parquet.TimestampType{
+			IsAdjustedToUTC: true,
+			Unit:            &parquet.TimeUnit{MICROS: parquet.NewMicroSeconds()},
+		}}
+	default:
+		element.Type = physical(parquet.Type_BYTE_ARRAY)
+		element.ConvertedType = converted(parquet.ConvertedType_UTF8)
+		element.LogicalType = &parquet.LogicalType{STRING: parquet.NewStringType()}
+	}
+	return element
+}
+
+func (e *parquetExport) append(row []interface{}) error {
+	for i, value := range row {
+		name := e.columns[i]
+		switch v := value.(type) {
+		case nil:
+			delete(e.row, name)
+		case *bool:
+			e.row[name] = *v
+		case *int64:
+			e.row[name] = *v
+		case *float64:
+			e.row[name] = *v
+		case *time.Time:
+			e.row[name] = v.UnixNano() / int64(time.Microsecond)
+		default:
+			e.row[name] = []byte(valueString(value))
+		}
+	}
+	if err := e.writer.AddData(e.row); err != nil {
+		return err
+	}
+	e.rows++
+	if e.rows%parquetRowGroupRows == 0 {
+		return e.writer.FlushRowGroup()
+	}
+	return nil
+}
+
+func (e *parquetExport) close(truncated bool) error {
+	if truncated {
+		e.metadata["export.truncated"] = "true"
+	}
+	return e.writer.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	goparquet "github.com/fraugster/parquet-go"
+)
+
+// exportResult has a NULL in every column.
+var exportResult = fakeResult{
+	columns: []string{"time", "value", "host"},
+	types:   []string{"TIMESTAMP", "FLOAT", "VARCHAR"},
+	rows: [][]driver.Value{
+		{time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), 1.5, "a"},
+		{nil, nil, nil},
+		{time.Date(2021, 6, 1, 0, 1, 0, 0, time.UTC), 2.5, nil},
+	},
+}
+
+func runExport(t *testing.T, format string, maxRows int) *httptest.ResponseRecorder {
+	db, _ := newFakeDB(fakeStatement{match: "FROM metrics", result: exportResult})
+	t.Cleanup(func() { db.Close() })
+	instance := &instanceSettings{Db: db, UID: "test"}
+	statement := "SELECT time, value, host FROM metrics"
+	recorder := httptest.NewRecorder()
+	instance.export(context.Background(), requestContext{}, recorder, queryModel{QueryString: statement, QueryTemplated: statement}, format, maxRows)
+	return recorder
+}
+
+func TestExportCSVNulls(t *testing.T) {
+	recorder := runExport(t, exportFormatCSV, 10)
+	want := "time,value,host\n2021-06-01T00:00:00Z,1.5,a\n,,\n2021-06-01T00:01:00Z,2.5,\n"
+	if got := recorder.Body.String(); got != want {
+		t.Errorf("csv export is\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExportArrowNulls(t *testing.T) {
+	recorder := runExport(t, exportFormatArrow, 10)
+	reader, err := ipc.NewReader(recorder.Body, ipc.WithAllocator(memory.NewGoAllocator()))
+	if err != nil {
+		t.Fatalf("reading the arrow export failed: %v, the response is %s", err, recorder.Body.String())
+	}
+	defer reader.Release()
+	if !reader.Next() {
+		t.Fatal("the arrow export has no record batch")
+	}
+	record := reader.Record()
+	if record.NumRows() != 3 {
+		t.Fatalf("the record batch has %d rows, want 3", record.NumRows())
+	}
+	for i := 0; i < 3; i++ {
+		if !record.Column(i).IsNull(1) {
+			t.Errorf("column %s of the NULL row is not null", record.ColumnName(i))
+		}
+	}
+	if !record.Column(2).IsNull(2) || record.Column(2).(*array.String).Value(0) != "a" {
+		t.Error("the host column does not hold a, NULL, NULL")
+	}
+	if value := record.Column(1).(*array.Float64).Value(2); value != 2.5 {
+		t.Errorf("the value of the last row is %v, want 2.5", value)
+	}
+}
+
+func TestExportParquetNulls(t *testing.T) {
+	recorder := runExport(t, exportFormatParquet, 10)
+	reader, err := goparquet.NewFileReader(bytes.NewReader(recorder.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("reading the parquet export failed: %v, the response is %s", err, recorder.Body.String())
+	}
+	if reader.NumRows() != 3 {
+		t.Fatalf("the parquet file has %d rows, want 3", reader.NumRows())
+	}
+	rows := make([]map[string]interface{}, 3)
+	for i := range rows {
+		if rows[i], err = reader.NextRow(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := rows[0]
+	if first["time"] != time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC).UnixNano()/int64(time.Microsecond) || first["value"] != 1.5 || string(first["host"].([]byte)) != "a" {
+		t.Errorf("the first row is %v", first)
+	}
+	if len(rows[1]) != 0 {
+		t.Errorf("the NULL row is %v, want no values", rows[1])
+	}
+	if _, ok := rows[2]["host"]; ok || rows[2]["value"] != 2.5 {
+		t.Errorf("the last row is %v, want value 2.5 and no host", rows[2])
+	}
+}
+
+func TestExportTruncated(t *testing.T) {
+	for _, format := range []string{exportFormatCSV, exportFormatArrow, exportFormatParquet} {
+		for maxRows, want := range map[int]string{2: "true", 3: "false"} {
+			recorder := runExport(t, format, maxRows)
+			if got := recorder.Header().Get(exportTruncatedHeader); got != want {
+				t.Errorf("%s export of 3 rows with maxRows %d has %s %q, want %q", format, maxRows, exportTruncatedHeader, got, want)
+			}
+		}
+	}
+	reader, err := goparquet.NewFileReader(bytes.NewReader(runExport(t, exportFormatParquet, 2).Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.NumRows() != 2 || reader.MetaData()["export.truncated"] != "true" {
+		t.Errorf("the truncated parquet file has %d rows and the metadata %v", reader.NumRows(), reader.MetaData())
+	}
+}
+
+func TestExportReadOnly(t *testing.T) {
+	db, connector := newFakeDB(fakeStatement{match: "FROM metrics", result: exportResult})
+	defer db.Close()
+	instance := &instanceSettings{Db: db, UID: "test"}
+	statement := "SELECT time, value, host FROM metrics"
+	instance.export(context.Background(), requestContext{}, httptest.NewRecorder(), queryModel{QueryString: statement, QueryTemplated: statement}, exportFormatCSV, 10)
+	if want := []string{readOnlySession, statement, readWriteSession}; !reflect.DeepEqual(connector.statements, want) {
+		t.Errorf("the export ran %q, want %q", connector.statements, want)
+	}
+
+	statement = "DELETE FROM metrics; SELECT time, value, host FROM metrics"
+	recorder := httptest.NewRecorder()
+	instance.export(context.Background(), requestContext{}, recorder, queryModel{QueryString: statement, QueryTemplated: statement}, exportFormatCSV, 10)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("the export of several statements answered %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	vertigo "github.com/vertica/vertica-sql-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// hostRetryInterval is how long a host that failed to connect is moved behind the healthy hosts.
+const hostRetryInterval = 30 * time.Second
+
+// hostHealth is the connection state of a single vertica node.
+type hostHealth struct {
+	Host        string
+	Healthy     bool
+	LastError   string
+	LastSuccess time.Time
+	LastFailure time.Time
+}
+
+// failoverConnector implements driver.Connector and is used as the connection source of the sql.DB pool.
+// every new physical connection is opened against the first available host, the primary host first
+// and then the backup hosts in configured order. hosts which failed recently are tried last.
+//
+// the driver's backup_server_node option is not used, as it does not cover what the connector does: the driver only
+// tries the backups when the tcp connection cannot be opened, a node refusing the session in the handshake fails the
+// connection. it does not tell which host it connected to, so neither the health of the hosts nor the serving host
+// could be tracked, and it tries a down primary first for every new connection, each time waiting for the dial to fail.
+type failoverConnector struct {
+	driver      driver.Driver
+	config      datasourceConfig
+	credentials credentialProvider
+
+	mtx      sync.Mutex
+	hosts    []*hostHealth
+	serving  string
+	template sessionTemplate
+}
+
+func newFailoverConnector(config datasourceConfig, credentials credentialProvider, template sessionTemplate) (*failoverConnector, error) {
+	// validate every host up front, so a bad backup host is reported on save
+	hosts, err := config.Hosts()
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(config.Database, "/") {
+		return nil, fmt.Errorf("invalid database %q: database name cannot contain '/'", config.Database)
+	}
+	connector := &failoverConnector{
+		driver:      &vertigo.Driver{},
+		config:      config,
+		credentials: credentials,
+		template:    template,
+	}
+	for _, host := range hosts {
+		connector.hosts = append(connector.hosts, &hostHealth{Host: host, Healthy: true})
+	}
+	return connector, nil
+}
+
+// Connect opens a new connection, failing over to the next host when a host cannot be reached.
+// a host refusing the session, e.g. because of wrong credentials, fails the connection without failing over.
+// when no host accepts the connection and the credentials can be refreshed, e.g. a rotated password,
+// the credentials are read again and, if they changed, the connection is retried with them.
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connect(ctx)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	if refresher, ok := c.credentials.(credentialRefresher); ok && refresher.Refresh() {
+		log.DefaultLogger.Info("credentials changed, retrying connection")
+		return c.connect(ctx)
+	}
+	return nil, err
+}
+
+func (c *failoverConnector) connect(ctx context.Context) (driver.Conn, error) {
+	// credentials are fetched for every connection, tokens and rotated secrets may have changed since the last one
+	creds, err := c.credentials.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]string, 0)
+	for _, host := range c.candidates() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dsn, err := c.config.ConnectionURL(host, creds)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := c.driver.Open(dsn)
+		if err != nil && !hostUnavailable(err) {
+			// the host refused the session, e.g. the credentials, which the other hosts refuse as well
+			return nil, err
+		}
+		if err != nil {
+			log.DefaultLogger.Warn("connection failed", "host", host, "error", err.Error())
+			c.markDown(host, err)
+			errs = append(errs, fmt.Sprintf("%s: %s", host, err))
+			continue
+		}
+		c.markUp(host)
+		// the host is fine, a failing init statement is a configuration error and is not retried on other hosts
+		if err := initializeConnection(ctx, conn, c.config.sessionStatements(c.sessionTemplate())); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("no vertica host reachable: %s", strings.Join(errs, "; "))
+}
+
+// Driver returns the underlying vertica driver.
+func (c *failoverConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// setOrgID sets the grafana org used by templated session statements.
+// the org is only known from the plugin context of a request, not when the instance is created.
+func (c *failoverConnector) setOrgID(orgID int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.template.OrgID = orgID
+}
+
+func (c *failoverConnector) sessionTemplate() sessionTemplate {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.template
+}
+
+// candidates returns the hosts in the order they should be tried.
+func (c *failoverConnector) candidates() []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := time.Now()
+	available := make([]string, 0, len(c.hosts))
+	down := make([]*hostHealth, 0)
+	for _, h := range c.hosts {
+		if h.Healthy || now.Sub(h.LastFailure) > hostRetryInterval {
+			available = append(available, h.Host)
+		} else {
+			down = append(down, h)
+		}
+	}
+	// hosts which failed longest ago are the most likely to be back
+	sort.SliceStable(down, func(i, j int) bool {
+		return down[i].LastFailure.Before(down[j].LastFailure)
+	})
+	for _, h := range down {
+		available = append(available, h.Host)
+	}
+	return available
+}
+
+func (c *failoverConnector) markUp(host string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, h := range c.hosts {
+		if h.Host == host {
+			if !h.Healthy {
+				log.DefaultLogger.Info("host is reachable again", "host", host)
+			}
+			h.Healthy = true
+			h.LastError = ""
+			h.LastSuccess = time.Now()
+		}
+	}
+	if c.serving != host {
+		log.DefaultLogger.Info("connections are served by a new host", "host", host)
+		c.serving = host
+	}
+}
+
+func (c *failoverConnector) markDown(host string, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, h := range c.hosts {
+		if h.Host == host {
+			h.Healthy = false
+			h.LastError = err.Error()
+			h.LastFailure = time.Now()
+		}
+	}
+}
+
+// Status returns the host which served the latest connection and a snapshot of every host.
+func (c *failoverConnector) Status() (string, []hostHealth) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	hosts := make([]hostHealth, 0, len(c.hosts))
+	for _, h := range c.hosts {
+		hosts = append(hosts, *h)
+	}
+	return c.serving, hosts
+}
+
+// queryWithReconnect runs the query on a pooled connection. when the connection turns out to be broken,
+// e.g. because the node serving it went down, it is discarded and the query is retried once on a new
+// connection, which the failover connector opens on the next reachable host.
+// setup, when not nil, runs on the connection before the query. the time spent is added to timing.
+// without retry a broken connection fails the query, for setups which must not run twice.
+// args are bound to the placeholders of the query.
+func queryWithReconnect(ctx context.Context, db *sql.DB, query string, setup func(*sql.Conn) error, retry bool, timing *queryTiming, args ...interface{}) (*sql.Conn, *sql.Rows, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		start := time.Now()
+		_, span := tracer.Start(ctx, "acquire connection", trace.WithAttributes(attribute.Int("attempt", attempt)))
+		connection, err := db.Conn(ctx)
+		if err != nil {
+			timing.Connect += time.Since(start)
+			endSpan(span, err)
+			return nil, nil, err
+		}
+		var rows *sql.Rows
+		if setup != nil {
+			err = setup(connection)
+		}
+		timing.Connect += time.Since(start)
+		endSpan(span, err)
+		if err == nil {
+			start = time.Now()
+			_, span = tracer.Start(ctx, "QueryContext")
+			rows, err = connection.QueryContext(ctx, query, args...)
+			timing.Execute += time.Since(start)
+			endSpan(span, err)
+		}
+		if err == nil {
+			return connection, rows, nil
+		}
+		if !retry || !isConnectionError(err) || ctx.Err() != nil {
+			if setup != nil {
+				// the session may have been changed by setup, do not hand it to the next query
+				discardConnection(connection)
+			} else {
+				connection.Close()
+			}
+			return nil, nil, err
+		}
+		log.DefaultLogger.Warn("discarding broken connection", "errorClass", errorClassConnection, "error", err.Error())
+		discardConnection(connection)
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+// isConnectionError reports whether err means the connection itself is unusable.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// driverSocketError starts the error the vertica driver returns as text when it cannot open a socket to the host.
+const driverSocketError = "Failed to establish a connection to the primary server or any backup host."
+
+// hostUnavailable reports whether err opening a connection means the host cannot serve connections, so the next
+// host is tried: the host cannot be reached or the connection broke, or the node is starting or shutting down and
+// refuses sessions with an operator intervention error, sql state class 57. other errors are the same on every host.
+func hostUnavailable(err error) bool {
+	var verr *vertigo.VError
+	if errors.As(err, &verr) {
+		return strings.HasPrefix(verr.SQLState, "57")
+	}
+	return isConnectionError(err) || strings.HasPrefix(err.Error(), driverSocketError)
+}
+
+// discardConnection closes the physical connection instead of returning it to the pool.
+func discardConnection(connection *sql.Conn) {
+	_ = connection.Raw(func(driverConn interface{}) error {
+		return driver.ErrBadConn
+	})
+	connection.Close()
+}
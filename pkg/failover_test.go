@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	vertigo "github.com/vertica/vertica-sql-go"
+)
+
+// hostsDriver fails to open connections to the hosts in errs with their error and records the hosts it was asked for.
+type hostsDriver struct {
+	errs   map[string]error
+	opened []string
+}
+
+func (d *hostsDriver) Open(dsn string) (driver.Conn, error) {
+	for host, err := range d.errs {
+		if strings.Contains(dsn, "@"+host+"/") {
+			d.opened = append(d.opened, host)
+			return nil, err
+		}
+	}
+	d.opened = append(d.opened, dsn)
+	return &fakeConn{connector: &fakeConnector{}}, nil
+}
+
+func TestConnectFailover(t *testing.T) {
+	unreachable := errors.New(driverSocketError + "\n  'primary:5433': dial tcp: connection refused")
+	for _, test := range []struct {
+		name   string
+		err    error
+		opened int
+		down   bool
+	}{
+		{"unreachable", unreachable, 2, true},
+		{"broken handshake", driver.ErrBadConn, 2, true},
+		{"node shutting down", &vertigo.VError{SQLState: "57P01", Message: "terminating connection due to administrator command"}, 2, true},
+		{"wrong password", &vertigo.VError{SQLState: "28000", Message: "Invalid username or password"}, 1, false},
+		{"bad tls", errors.New("x509: certificate signed by unknown authority"), 1, false},
+	} {
+		hosts := &hostsDriver{errs: map[string]error{"primary:5433": test.err}}
+		connector := &failoverConnector{
+			driver:      hosts,
+			config:      datasourceConfig{Database: "db", User: "u"},
+			credentials: staticCredentials{Password: "p"},
+			hosts:       []*hostHealth{{Host: "primary:5433", Healthy: true}, {Host: "backup:5433", Healthy: true}},
+		}
+		conn, err := connector.Connect(context.Background())
+		if len(hosts.opened) != test.opened {
+			t.Errorf("%s: opened %d hosts, want %d", test.name, len(hosts.opened), test.opened)
+		}
+		if test.opened == 2 && (err != nil || conn == nil) {
+			t.Errorf("%s: failing over returned %v", test.name, err)
+		}
+		if test.opened == 1 && !errors.Is(err, test.err) {
+			t.Errorf("%s: returned %v, want the error of the primary", test.name, err)
+		}
+		serving, health := connector.Status()
+		if health[0].Healthy == test.down {
+			t.Errorf("%s: the primary is healthy %v, want %v", test.name, health[0].Healthy, !test.down)
+		}
+		if test.down && serving != "backup:5433" {
+			t.Errorf("%s: served by %q, want the backup", test.name, serving)
+		}
+	}
+}
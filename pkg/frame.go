@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// columnChunkRows is the number of values allocated at once by a column builder, and the initial capacity of its vector.
+const columnChunkRows = 1024
+
+// columnBuilder builds the vector of a frame field from scanned values. the value of a row is scanned into
+// the reused sql.Null* scan buffer of the builder and appended to its typed vector, without reflection.
+type columnBuilder interface {
+	// dest is the scan buffer passed to rows.Scan
+	dest() interface{}
+	// append copies the scanned value to the vector
+	append()
+	// vector returns the values for data.NewField
+	vector() interface{}
+}
+
+// frameBuilder builds a frame column by column from scanned rows, it replaces Frame.AppendRow, which
+// converts every cell with reflection.
+type frameBuilder struct {
+	columns []columnBuilder
+	rowIn   []interface{}
+}
+
+// newFrameBuilder returns a builder with a typed column for the field type of every column, see generateFrameType.
+func newFrameBuilder(columnTypes []*sql.ColumnType) *frameBuilder {
+	b := &frameBuilder{
+		columns: make([]columnBuilder, len(columnTypes)),
+		rowIn:   make([]interface{}, len(columnTypes)),
+	}
+	for i, fieldType := range generateFrameType(columnTypes) {
+		switch fieldType {
+		case data.FieldTypeNullableBool:
+			b.columns[i] = &boolColumn{values: make([]*bool, 0, columnChunkRows)}
+		case data.FieldTypeNullableInt64:
+			b.columns[i] = &int64Column{values: make([]*int64, 0, columnChunkRows)}
+		case data.FieldTypeNullableFloat64:
+			b.columns[i] = &float64Column{values: make([]*float64, 0, columnChunkRows)}
+		case data.FieldTypeNullableTime:
+			b.columns[i] = &timeColumn{values: make([]*time.Time, 0, columnChunkRows)}
+		default:
+			b.columns[i] = &stringColumn{values: make([]*string, 0, columnChunkRows)}
+		}
+		b.rowIn[i] = b.columns[i].dest()
+	}
+	return b
+}
+
+// scanDest returns the scan buffers of a row, the same for every row.
+func (b *frameBuilder) scanDest() []interface{} {
+	return b.rowIn
+}
+
+// appendRow appends the scanned row to the columns.
+func (b *frameBuilder) appendRow() {
+	for _, column := range b.columns {
+		column.append()
+	}
+}
+
+// frame returns the frame of the appended rows, with the column names as field names.
+func (b *frameBuilder) frame(name string, columns []string) *data.Frame {
+	fields := make([]*data.Field, len(b.columns))
+	for i, column := range b.columns {
+		fields[i] = data.NewField(columns[i], nil, column.vector())
+	}
+	return data.NewFrame(name, fields...)
+}
+
+// scanFrame reads rows into a frame named name, nil when the statement returned no result set. on error it
+// returns the failed step.
+func scanFrame(rows *sql.Rows, name string, observation *queryObservation) (*data.Frame, string, error) {
+	//get the column names, columns will be use to added a header names to the data frame
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, "columns", err
+	}
+	if len(columns) == 0 {
+		return nil, "", rows.Err()
+	}
+	//get the column types, column type will be used to convert column from sql type to data frame type. (implemented in types.go generateFrameType)
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, "columnTypes", err
+	}
+	builder := newFrameBuilder(columnTypes)
+	rowIn := builder.scanDest()
+	values := make([]interface{}, len(rowIn))
+	for rows.Next() {
+		if err := rows.Scan(rowIn...); err != nil {
+			observation.stage = errorClassConversion
+			return nil, "row.Scan", err
+		}
+		scannedValues(rowIn, values)
+		observation.scanned(values)
+		builder.appendRow()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "rows.Err", err
+	}
+	return builder.frame(name, columns), "", nil
+}
+
+// setFrameCustom sets key in the custom meta of frame, keeping the other keys.
+func setFrameCustom(frame *data.Frame, key string, value interface{}) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	custom, ok := frame.Meta.Custom.(map[string]interface{})
+	if !ok {
+		custom = make(map[string]interface{})
+		frame.Meta.Custom = custom
+	}
+	custom[key] = value
+}
+
+// the fields are nullable, a value is a pointer into a chunk of values allocated with columnChunkRows of them,
+// or nil for NULL.
+
+type boolColumn struct {
+	scan   sql.NullBool
+	chunk  []bool
+	values []*bool
+}
+
+func (c *boolColumn) dest() interface{} { return &c.scan }
+
+func (c *boolColumn) append() {
+	if !c.scan.Valid {
+		c.values = append(c.values, nil)
+		return
+	}
+	if len(c.chunk) == cap(c.chunk) {
+		c.chunk = make([]bool, 0, columnChunkRows)
+	}
+	c.chunk = append(c.chunk, c.scan.Bool)
+	c.values = append(c.values, &c.chunk[len(c.chunk)-1])
+}
+
+func (c *boolColumn) vector() interface{} { return c.values }
+
+type int64Column struct {
+	scan   sql.NullInt64
+	chunk  []int64
+	values []*int64
+}
+
+func (c *int64Column) dest() interface{} { return &c.scan }
+
+func (c *int64Column) append() {
+	if !c.scan.Valid {
+		c.values = append(c.values, nil)
+		return
+	}
+	if len(c.chunk) == cap(c.chunk) {
+		c.chunk = make([]int64, 0, columnChunkRows)
+	}
+	c.chunk = append(c.chunk, c.scan.Int64)
+	c.values = append(c.values, &c.chunk[len(c.chunk)-1])
+}
+
+func (c *int64Column) vector() interface{} { return c.values }
+
+type float64Column struct {
+	scan   sql.NullFloat64
+	chunk  []float64
+	values []*float64
+}
+
+func (c *float64Column) dest() interface{} { return &c.scan }
+
+func (c *float64Column) append() {
+	if !c.scan.Valid {
+		c.values = append(c.values, nil)
+		return
+	}
+	if len(c.chunk) == cap(c.chunk) {
+		c.chunk = make([]float64, 0, columnChunkRows)
+	}
+	c.chunk = append(c.chunk, c.scan.Float64)
+	c.values = append(c.values, &c.chunk[len(c.chunk)-1])
+}
+
+func (c *float64Column) vector() interface{} { return c.values }
+
+type stringColumn struct {
+	scan   sql.NullString
+	chunk  []string
+	values []*string
+}
+
+func (c *stringColumn) dest() interface{} { return &c.scan }
+
+func (c *stringColumn) append() {
+	if !c.scan.Valid {
+		c.values = append(c.values, nil)
+		return
+	}
+	if len(c.chunk) == cap(c.chunk) {
+		c.chunk = make([]string, 0, columnChunkRows)
+	}
+	c.chunk = append(c.chunk, c.scan.String)
+	c.values = append(c.values, &c.chunk[len(c.chunk)-1])
+}
+
+func (c *stringColumn) vector() interface{} { return c.values }
+
+type timeColumn struct {
+	scan   sql.NullTime
+	chunk  []time.Time
+	values []*time.Time
+}
+
+func (c *timeColumn) dest() interface{} { return &c.scan }
+
+func (c *timeColumn) append() {
+	if !c.scan.Valid {
+		c.values = append(c.values, nil)
+		return
+	}
+	if len(c.chunk) == cap(c.chunk) {
+		c.chunk = make([]time.Time, 0, columnChunkRows)
+	}
+	c.chunk = append(c.chunk, c.scan.Time)
+	c.values = append(c.values, &c.chunk[len(c.chunk)-1])
+}
+
+func (c *timeColumn) vector() interface{} { return c.values }
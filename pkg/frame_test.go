@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// benchmarkColumnTypes are the vertica types of the benchmark results, repeated for wide results.
+var benchmarkColumnTypes = []string{"TIMESTAMP", "INT", "FLOAT", "VARCHAR", "BOOL"}
+
+// benchmarkResult returns a result of rows with columns columns of the benchmark column types.
+func benchmarkResult(columns, rows int) fakeResult {
+	result := fakeResult{columns: make([]string, columns), types: make([]string, columns), rows: make([][]driver.Value, rows)}
+	for i := range result.columns {
+		result.columns[i] = fmt.Sprintf("c%d", i)
+		result.types[i] = benchmarkColumnTypes[i%len(benchmarkColumnTypes)]
+	}
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for row := range result.rows {
+		values := make([]driver.Value, columns)
+		for i, columnType := range result.types {
+			switch columnType {
+			case "TIMESTAMP":
+				values[i] = start.Add(time.Duration(row) * time.Second)
+			case "INT":
+				values[i] = int64(row * i)
+			case "FLOAT":
+				values[i] = float64(row) / float64(i+1)
+			case "VARCHAR":
+				values[i] = fmt.Sprintf("node%04d", row%100)
+			case "BOOL":
+				values[i] = row%2 == 0
+			}
+		}
+		result.rows[row] = values
+	}
+	return result
+}
+
+// appendRowFrame reads rows into a frame with Frame.AppendRow, as the frames were built before the column builders,
+// with NULL values as nil.
+func appendRowFrame(rows *sql.Rows, name string) (*data.Frame, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	frame := data.NewFrameOfFieldTypes(name, 0, generateFrameType(columnTypes)...)
+	if err := frame.SetFieldNames(columns...); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		rowIn := generateNullableRowIn(columnTypes)
+		if err := rows.Scan(rowIn...); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(rowIn))
+		scannedValues(rowIn, values)
+		frame.AppendRow(values...)
+	}
+	return frame, rows.Err()
+}
+
+// benchmarkScanFrame reads a result of rows with columns columns with the AppendRow path and with the column builders.
+func benchmarkScanFrame(b *testing.B, columns, rows int) {
+	db, _ := newFakeDB(fakeStatement{match: "SELECT", result: benchmarkResult(columns, rows)})
+	defer db.Close()
+	read := map[string]func(*sql.Rows) (*data.Frame, error){
+		"AppendRow": func(rows *sql.Rows) (*data.Frame, error) {
+			return appendRowFrame(rows, "A")
+		},
+		"ColumnBuilder": func(rows *sql.Rows) (*data.Frame, error) {
+			frame, _, err := scanFrame(rows, "A", &queryObservation{})
+			return frame, err
+		},
+	}
+	for _, name := range []string{"AppendRow", "ColumnBuilder"} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				result, err := db.QueryContext(context.Background(), "SELECT")
+				if err != nil {
+					b.Fatal(err)
+				}
+				frame, err := read[name](result)
+				result.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if frame.Rows() != rows {
+					b.Fatalf("read %d rows, want %d", frame.Rows(), rows)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScanFrameLong(b *testing.B) {
+	benchmarkScanFrame(b, 5, 100000)
+}
+
+func BenchmarkScanFrameWide(b *testing.B) {
+	benchmarkScanFrame(b, 200, 1000)
+}
+
+func TestScanFrameMatchesAppendRow(t *testing.T) {
+	result := benchmarkResult(10, 2500)
+	// a row of NULLs in the middle of the result, and a NULL in every column on its own
+	result.rows[1000] = make([]driver.Value, len(result.columns))
+	for i := range result.columns {
+		result.rows[1100+i][i] = nil
+	}
+	db, _ := newFakeDB(fakeStatement{match: "SELECT", result: result})
+	defer db.Close()
+	frames := make([]*data.Frame, 2)
+	for i := range frames {
+		rows, err := db.QueryContext(context.Background(), "SELECT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			frames[i], err = appendRowFrame(rows, "A")
+		} else {
+			frames[i], _, err = scanFrame(rows, "A", &queryObservation{})
+		}
+		rows.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, got := frames[0], frames[1]
+	if got.Rows() != want.Rows() || len(got.Fields) != len(want.Fields) {
+		t.Fatalf("got %d rows of %d fields, want %d rows of %d fields", got.Rows(), len(got.Fields), want.Rows(), len(want.Fields))
+	}
+	for _, field := range got.Fields {
+		if value, ok := field.ConcreteAt(1000); ok {
+			t.Fatalf("NULL of field %s is %v, want nil", field.Name, value)
+		}
+	}
+	for i, field := range want.Fields {
+		if got.Fields[i].Name != field.Name || got.Fields[i].Type() != field.Type() {
+			t.Fatalf("field %d is %s %s, want %s %s", i, got.Fields[i].Name, got.Fields[i].Type(), field.Name, field.Type())
+		}
+		for row := 0; row < want.Rows(); row++ {
+			wantValue, _ := field.ConcreteAt(row)
+			gotValue, _ := got.Fields[i].ConcreteAt(row)
+			if gotValue != wantValue {
+				t.Fatalf("field %s row %d is %v, want %v", field.Name, row, gotValue, wantValue)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// user identity modes. with "connection" queries run on connections logged in as the vertica user mapped
+// to the grafana user, with "role" queries run as the data source user with the mapped roles set.
+const (
+	identityModeConnection = "connection"
+	identityModeRole       = "role"
+)
+
+// userPasswordPrefix prefixes the secure json keys holding the passwords of mapped vertica users, e.g. "password.alice".
+const userPasswordPrefix = "password."
+
+// userIdentityConfig configures forwarding the grafana user to vertica, so row level access policies and auditing apply.
+// grafana users without mapping cannot query the data source.
+type userIdentityConfig struct {
+	Mode  string              `json:"mode,omitempty"`
+	Users map[string]string   `json:"users,omitempty"`
+	Roles map[string][]string `json:"roles,omitempty"`
+}
+
+// verticaIdentity is the vertica login a query runs as.
+// with a forwarded oauth token the vertica user is taken from the token, Login is the grafana user it belongs to.
+type verticaIdentity struct {
+	User        string
+	Password    string
+	AccessToken string
+	Login       string
+}
+
+// key identifies the connection pool of the identity.
+func (identity *verticaIdentity) key() string {
+	if identity == nil {
+		return ""
+	}
+	if identity.AccessToken != "" {
+		return "oauth:" + identity.Login
+	}
+	return identity.User
+}
+
+// lookup finds the mapping of a grafana login, logins are matched case insensitive.
+func lookup(login string, mapping map[string]string) (string, bool) {
+	for key, value := range mapping {
+		if strings.EqualFold(key, login) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// resolve returns the vertica identity or the roles the query of a grafana user runs with.
+// it fails when identity forwarding is enabled and the user has no mapping.
+func (config *userIdentityConfig) resolve(user *backend.User, secureJSONData map[string]string) (*verticaIdentity, []string, error) {
+	if config.Mode == "" {
+		return nil, nil, nil
+	}
+	if user == nil || user.Login == "" {
+		return nil, nil, fmt.Errorf("user identity forwarding is enabled but the request has no grafana user")
+	}
+	switch config.Mode {
+	case identityModeConnection:
+		verticaUser, ok := lookup(user.Login, config.Users)
+		if !ok || verticaUser == "" {
+			return nil, nil, fmt.Errorf("no vertica user is mapped to grafana user %q", user.Login)
+		}
+		password, ok := secureJSONData[userPasswordPrefix+verticaUser]
+		if !ok {
+			return nil, nil, fmt.Errorf("no password is configured for vertica user %q", verticaUser)
+		}
+		return &verticaIdentity{User: verticaUser, Password: password}, nil, nil
+	case identityModeRole:
+		for login, roles := range config.Roles {
+			if strings.EqualFold(login, user.Login) && len(roles) > 0 {
+				return nil, roles, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("no vertica role is mapped to grafana user %q", user.Login)
+	default:
+		return nil, nil, fmt.Errorf("unknown user identity mode %q", config.Mode)
+	}
+}
+
+// roleStatements returns the statements enabling the roles for a query and restoring the default roles afterwards.
+func roleStatements(roles []string) ([]string, []string) {
+	quoted := make([]string, 0, len(roles))
+	for _, role := range roles {
+		quoted = append(quoted, quoteIdentifier(role))
+	}
+	return []string{"SET ROLE " + strings.Join(quoted, ", ")}, []string{"SET ROLE DEFAULT"}
+}
+
+// sessionStatement matches the statements changing the roles or the user of the session, SET ROLE and SET SESSION.
+var sessionStatement = regexp.MustCompile(`(?i)^[\s(]*SET\s+(ROLE|SESSION)\b`)
+
+// checkSessionStatements returns an error when one of the statements of a query run with mapped roles changes
+// the roles or the user of the session, which would bypass the role mapping.
+func checkSessionStatements(statements []string) error {
+	for _, statement := range statements {
+		if match := sessionStatement.FindStringSubmatch(stripComments(redactLiterals(statement))); match != nil {
+			return fmt.Errorf("queries of this data source run with the roles mapped to the grafana user, SET %s is not allowed", strings.ToUpper(match[1]))
+		}
+	}
+	return nil
+}
+
+// forwardsUserToken reports whether queries log in with the oauth token of the grafana user.
+func (config *datasourceConfig) forwardsUserToken() bool {
+	return config.AuthType == authTypeOAuth && config.OAuth.ForwardUserToken
+}
+
+// forwardedIdentity returns the identity of the grafana user whose oauth token grafana forwarded with the request.
+// the connections of a token are pooled by the login, a request without grafana user is refused so that tokens of
+// different users never share a pool.
+func forwardedIdentity(rc requestContext) (*verticaIdentity, error) {
+	token := bearerToken(rc.header("Authorization"))
+	if token == "" {
+		return nil, errors.New("no oauth token was forwarded by grafana, enable forwarding the oauth identity of the user for this data source")
+	}
+	if rc.PluginContext.User == nil || rc.PluginContext.User.Login == "" {
+		return nil, errors.New("an oauth token was forwarded but the request has no grafana user")
+	}
+	return &verticaIdentity{AccessToken: token, Login: rc.PluginContext.User.Login}, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestForwardedIdentity(t *testing.T) {
+	header := map[string]string{"authorization": "Bearer token"}
+	for _, test := range []struct {
+		name    string
+		rc      requestContext
+		key     string
+		refused bool
+	}{
+		{"user", requestContext{PluginContext: backend.PluginContext{User: &backend.User{Login: "alice"}}, Headers: header}, "oauth:alice", false},
+		{"no token", requestContext{PluginContext: backend.PluginContext{User: &backend.User{Login: "alice"}}}, "", true},
+		{"no user", requestContext{Headers: header}, "", true},
+		{"empty login", requestContext{PluginContext: backend.PluginContext{User: &backend.User{Email: "a@example.com"}}, Headers: header}, "", true},
+	} {
+		identity, err := forwardedIdentity(test.rc)
+		if test.refused {
+			if err == nil {
+				t.Errorf("%s: the identity %+v was accepted", test.name, identity)
+			}
+			continue
+		}
+		if err != nil || identity.AccessToken != "token" || identity.key() != test.key {
+			t.Errorf("%s: got %+v, %v, want the pool %q", test.name, identity, err, test.key)
+		}
+	}
+}
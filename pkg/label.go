@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// query label modes, the label is added as a LABEL hint, set as the session client label or both.
+const (
+	queryLabelHint        = "hint"
+	queryLabelClientLabel = "clientLabel"
+	queryLabelBoth        = "both"
+)
+
+// maxLabelLength is the maximum length of a vertica label.
+const maxLabelLength = 128
+
+const defaultQueryLabelTemplate = "grafana_${__user.login}_${__dashboard.uid}_${__panel.id}_${__query.refId}"
+
+// queryLabelConfig configures the label queries are tagged with, so they can be attributed in v_monitor.query_requests.
+type queryLabelConfig struct {
+	Mode     string `json:"mode,omitempty"`
+	Template string `json:"template,omitempty"`
+}
+
+// requestContext carries what a query needs from the request it is part of.
+type requestContext struct {
+	PluginContext backend.PluginContext
+	Headers       map[string]string
+}
+
+// header returns a request header, header names are matched case insensitive.
+func (rc requestContext) header(name string) string {
+	for key, value := range rc.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// label renders the label template for a query, an empty label means queries are not labeled.
+func (config *queryLabelConfig) label(rc requestContext, qm queryModel, refID string) string {
+	if config.Mode == "" {
+		return ""
+	}
+	template := config.Template
+	if template == "" {
+		template = defaultQueryLabelTemplate
+	}
+	var login string
+	if rc.PluginContext.User != nil {
+		login = rc.PluginContext.User.Login
+	}
+	dashboardUID := qm.DashboardUID
+	if dashboardUID == "" {
+		dashboardUID = rc.header("X-Dashboard-Uid")
+	}
+	panelID := rc.header("X-Panel-Id")
+	if qm.PanelID != 0 {
+		panelID = strconv.FormatInt(qm.PanelID, 10)
+	}
+	var datasourceName, datasourceUID string
+	if settings := rc.PluginContext.DataSourceInstanceSettings; settings != nil {
+		datasourceName, datasourceUID = settings.Name, settings.UID
+	}
+	return strings.NewReplacer(
+		"${__user.login}", login,
+		"${__org.id}", strconv.FormatInt(rc.PluginContext.OrgID, 10),
+		"${__dashboard.uid}", dashboardUID,
+		"${__panel.id}", panelID,
+		"${__query.refId}", refID,
+		"${__datasource.name}", datasourceName,
+		"${__datasource.uid}", datasourceUID,
+	).Replace(template)
+}
+
+// useHint reports whether the label is added to the statement as a LABEL hint.
+func (config *queryLabelConfig) useHint() bool {
+	return config.Mode == queryLabelHint || config.Mode == queryLabelBoth
+}
+
+// useClientLabel reports whether the label is set as the client label of the session.
+func (config *queryLabelConfig) useClientLabel() bool {
+	return config.Mode == queryLabelClientLabel || config.Mode == queryLabelBoth
+}
+
+// labelIdentifier turns a label into a valid LABEL hint argument, anything but letters, digits and underscores is replaced.
+func labelIdentifier(label string) string {
+	identifier := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			return r
+		}
+		return '_'
+	}, label)
+	return truncateLabel(identifier)
+}
+
+// truncateLabel cuts label to the maximum length of a vertica label, in bytes, without splitting a character.
+func truncateLabel(label string) string {
+	if len(label) <= maxLabelLength {
+		return label
+	}
+	end := maxLabelLength
+	for end > 0 && !utf8.RuneStart(label[end]) {
+		end--
+	}
+	return label[:end]
+}
+
+// addLabelHint adds a /*+LABEL(...)*/ hint after the SELECT keyword the statement starts with.
+// statements not starting with SELECT, or already carrying a hint, are returned unchanged.
+func addLabelHint(statement string, label string) string {
+	start := skipLeadingComments(statement)
+	if len(statement)-start < len("SELECT") || !strings.EqualFold(statement[start:start+len("SELECT")], "SELECT") {
+		return statement
+	}
+	end := start + len("SELECT")
+	rest := statement[end:]
+	if rest != "" && !unicode.IsSpace(rune(rest[0])) && rest[0] != '/' && rest[0] != '(' && rest[0] != '*' {
+		// an identifier starting with select, e.g. selected_rows
+		return statement
+	}
+	if strings.HasPrefix(strings.TrimLeftFunc(rest, unicode.IsSpace), "/*+") {
+		return statement
+	}
+	return statement[:end] + " /*+LABEL(" + labelIdentifier(label) + ")*/" + rest
+}
+
+// skipLeadingComments returns the index of the first character of statement which is not whitespace or a comment.
+func skipLeadingComments(statement string) int {
+	i := 0
+	for i < len(statement) {
+		switch {
+		case unicode.IsSpace(rune(statement[i])):
+			i++
+		case strings.HasPrefix(statement[i:], "--"):
+			newline := strings.IndexByte(statement[i:], '\n')
+			if newline < 0 {
+				return len(statement)
+			}
+			i += newline + 1
+		case strings.HasPrefix(statement[i:], "/*") && !strings.HasPrefix(statement[i:], "/*+"):
+			closing := strings.Index(statement[i+2:], "*/")
+			if closing < 0 {
+				return len(statement)
+			}
+			i += closing + 4
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// clientLabelStatements returns the statements setting the client label of the session for a query
+// and the statements restoring the configured client label afterwards.
+func (s *instanceSettings) clientLabelStatements(label string) ([]string, []string) {
+	label = truncateLabel(label)
+	reset := s.connector.sessionTemplate().replace(s.config.SessionParameters.ClientLabel)
+	return []string{"SELECT SET_CLIENT_LABEL(" + quoteLiteral(label) + ")"},
+		[]string{"SELECT SET_CLIENT_LABEL(" + quoteLiteral(reset) + ")"}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestTruncateLabel(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		label string
+		want  string
+	}{
+		{"short", "grafana_alice", "grafana_alice"},
+		{"exact", strings.Repeat("a", maxLabelLength), strings.Repeat("a", maxLabelLength)},
+		{"long", strings.Repeat("a", maxLabelLength+10), strings.Repeat("a", maxLabelLength)},
+		// é is two bytes, the 128th byte is the first byte of the last one
+		{"two byte character", strings.Repeat("a", maxLabelLength-1) + "é", strings.Repeat("a", maxLabelLength-1)},
+		// 日 is three bytes, 42 of them end at byte 126, the 43rd would end at byte 129
+		{"three byte characters", strings.Repeat("日", 50), strings.Repeat("日", 42)},
+		{"four byte characters", strings.Repeat("🙂", 40), strings.Repeat("🙂", 32)},
+	} {
+		got := truncateLabel(test.label)
+		if got != test.want || !utf8.ValidString(got) || len(got) > maxLabelLength {
+			t.Errorf("%s: truncateLabel = %q (%d bytes), want %q", test.name, got, len(got), test.want)
+		}
+	}
+}
+
+func TestLabelIdentifier(t *testing.T) {
+	for label, want := range map[string]string{
+		"grafana_alice_d1_2_A":   "grafana_alice_d1_2_A",
+		"grafana_a.b@c-d_A":      "grafana_a_b_c_d_A",
+		"grafana_jörg_A":         "grafana_j_rg_A",
+		strings.Repeat("日", 200): strings.Repeat("_", maxLabelLength),
+	} {
+		if got := labelIdentifier(label); got != want {
+			t.Errorf("labelIdentifier(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
+
+func TestClientLabelStatements(t *testing.T) {
+	s := &instanceSettings{
+		connector: &failoverConnector{template: sessionTemplate{DatasourceUID: "abc"}},
+		config:    datasourceConfig{SessionParameters: sessionParameters{ClientLabel: "grafana_${__datasource.uid}"}},
+	}
+	label := strings.Repeat("a", maxLabelLength-1) + "'é"
+	set, reset := s.clientLabelStatements(label)
+	if want := []string{"SELECT SET_CLIENT_LABEL('" + strings.Repeat("a", maxLabelLength-1) + "''')"}; !reflect.DeepEqual(set, want) {
+		t.Errorf("the label is set with %q, want %q", set, want)
+	}
+	if want := []string{"SELECT SET_CLIENT_LABEL('grafana_abc')"}; !reflect.DeepEqual(reset, want) {
+		t.Errorf("the label is reset with %q, want %q", reset, want)
+	}
+}
+
+func TestQueryLabel(t *testing.T) {
+	rc := requestContext{
+		PluginContext: backend.PluginContext{
+			OrgID:                      3,
+			User:                       &backend.User{Login: "alice"},
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{Name: "dw", UID: "abc"},
+		},
+		Headers: map[string]string{"x-dashboard-uid": "d1", "X-Panel-Id": "4"},
+	}
+	for _, test := range []struct {
+		config queryLabelConfig
+		qm     queryModel
+		want   string
+	}{
+		{queryLabelConfig{}, queryModel{}, ""},
+		{queryLabelConfig{Mode: queryLabelHint}, queryModel{}, "grafana_alice_d1_4_A"},
+		{queryLabelConfig{Mode: queryLabelBoth}, queryModel{DashboardUID: "d2", PanelID: 5}, "grafana_alice_d2_5_A"},
+		{queryLabelConfig{Mode: queryLabelClientLabel, Template: "${__org.id}/${__datasource.name}/${__datasource.uid}"}, queryModel{}, "3/dw/abc"},
+	} {
+		if got := test.config.label(rc, test.qm, "A"); got != test.want {
+			t.Errorf("the label of %+v is %q, want %q", test.config, got, test.want)
+		}
+	}
+}
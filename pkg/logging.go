@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// auditLogConfig enables the audit log, which records every statement executed for a grafana user.
+type auditLogConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// RedactLiterals replaces the string literals of the logged statements, they may hold sensitive values
+	RedactLiterals bool `json:"redactLiterals,omitempty"`
+}
+
+// userLogin returns the login of the grafana user of the request, empty for requests made by grafana itself.
+func (rc requestContext) userLogin() string {
+	if rc.PluginContext.User == nil {
+		return ""
+	}
+	return rc.PluginContext.User.Login
+}
+
+// logFields returns the structured fields identifying a query in the logs.
+func (s *instanceSettings) logFields(rc requestContext, refID string) []interface{} {
+	return []interface{}{"datasource", s.Name, "datasourceUid", s.UID, "refId", refID, "user", rc.userLogin()}
+}
+
+// logQuery logs the outcome of a query, at a level matching the error class, and writes the audit log entry
+// when a statement was executed.
+func (s *instanceSettings) logQuery(rc requestContext, refID string, o *queryObservation, err error) {
+	fields := append(s.logFields(rc, refID), "format", o.labels[1], "duration", o.duration(), "rows", o.rows)
+	if err == nil {
+		log.DefaultLogger.Debug("query finished", fields...)
+	} else {
+		class := errorClass(err, o.stage)
+		fields = append(fields, "step", o.step, "errorClass", class, "error", err.Error())
+		switch class {
+		case errorClassCanceled:
+			// the dashboard was closed or refreshed before the query finished
+			log.DefaultLogger.Debug("query canceled", fields...)
+		case errorClassRequest, errorClassQuery:
+			// errors in the query or its settings, caused by the user and not by the plugin or vertica
+			log.DefaultLogger.Warn("query failed", fields...)
+		default:
+			log.DefaultLogger.Error("query failed", fields...)
+		}
+	}
+	if o.statement != "" {
+		s.audit(rc, refID, o.statement, err, o.stage, o.duration(), o.rows)
+	}
+}
+
+// audit writes the audit log entry of an executed statement, the outcome is success or the class of err.
+func (s *instanceSettings) audit(rc requestContext, refID string, statement string, err error, stage string, duration time.Duration, rows int) {
+	if !s.config.AuditLog.Enabled {
+		return
+	}
+	if s.config.AuditLog.RedactLiterals {
+		statement = redactLiterals(statement)
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = errorClass(err, stage)
+	}
+	log.DefaultLogger.Info("audit", append(s.logFields(rc, refID),
+		"statement", statement, "outcome", outcome, "duration", duration, "rows", rows)...)
+}
+
+// duration is the time since the query started.
+func (o *queryObservation) duration() time.Duration {
+	return time.Since(o.start)
+}
+
+// redactLiterals replaces the string literals of a statement with '?'. comments, quoted identifiers
+// and dollar quoted strings are recognised, so quotes inside them do not confuse the redaction.
+func redactLiterals(statement string) string {
+	var out strings.Builder
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == '-' && strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				end = len(statement) - i
+			}
+			out.WriteString(statement[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				end = len(statement) - i
+			} else {
+				end += 4
+			}
+			out.WriteString(statement[i : i+end])
+			i += end
+		case c == '"':
+			end := quotedEnd(statement, i, '"', false)
+			out.WriteString(statement[i:end])
+			i = end
+		case c == '\'':
+			// E'...' strings allow backslash escapes
+			escapes := i > 0 && (statement[i-1] == 'E' || statement[i-1] == 'e')
+			out.WriteString("'?'")
+			i = quotedEnd(statement, i, '\'', escapes)
+		case c == '$':
+			tag := dollarTag(statement[i:])
+			if tag == "" {
+				out.WriteByte(c)
+				i++
+				continue
+			}
+			out.WriteString("'?'")
+			end := strings.Index(statement[i+len(tag):], tag)
+			if end < 0 {
+				i = len(statement)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// quotedEnd returns the index after the quoted text starting at start. a doubled quote is part of the text.
+func quotedEnd(statement string, start int, quote byte, escapes bool) int {
+	for i := start + 1; i < len(statement); i++ {
+		switch statement[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(statement) && statement[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(statement)
+}
+
+// dollarTag returns the opening tag of a dollar quoted string, $$ or $tag$, or empty when s does not start one.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestRedactLiterals(t *testing.T) {
+	for _, test := range []struct{ statement, want string }{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM t WHERE name = 'alice'", "SELECT * FROM t WHERE name = '?'"},
+		{"SELECT 'it''s', 'b'", "SELECT '?', '?'"},
+		{"SELECT '''quoted''' AS q", "SELECT '?' AS q"},
+		{`SELECT E'it\'s', 'b'`, `SELECT E'?', '?'`},
+		{`SELECT e'a\\', 'b'`, `SELECT e'?', '?'`},
+		// without E backslashes are no escapes
+		{`SELECT 'a\', 'b'`, `SELECT '?', '?'`},
+		{`SELECT "it's" FROM "a""b'" WHERE x = 'y'`, `SELECT "it's" FROM "a""b'" WHERE x = '?'`},
+		{"SELECT 1 -- it's\nFROM t WHERE x = 'y'", "SELECT 1 -- it's\nFROM t WHERE x = '?'"},
+		{"SELECT /* it's */ 'y'", "SELECT /* it's */ '?'"},
+		{"SELECT /*+LABEL(x)*/ 'y'", "SELECT /*+LABEL(x)*/ '?'"},
+		{"SELECT $$it's$$, 'y'", "SELECT '?', '?'"},
+		{"SELECT $body$ it's $$ $body$, 'y'", "SELECT '?', '?'"},
+		{"SELECT $1, 'y' FROM t WHERE a = ?", "SELECT $1, '?' FROM t WHERE a = ?"},
+		{"SELECT 'open", "SELECT '?'"},
+		{"SELECT $$open", "SELECT '?'"},
+		{`SELECT "open`, `SELECT "open`},
+		{"SELECT 1 /* open 'x'", "SELECT 1 /* open 'x'"},
+		{"SELECT 'ä''ö', 'ü'", "SELECT '?', '?'"},
+	} {
+		if got := redactLiterals(test.statement); got != test.want {
+			t.Errorf("redactLiterals(%q) = %q, want %q", test.statement, got, test.want)
+		}
+	}
+}
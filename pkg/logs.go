@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryTypeLogs is the query type of log queries, their result is returned as log frames.
+const queryTypeLogs = "Logs"
+
+// grafana log levels, the severities log levels are mapped to.
+const (
+	logLevelCritical = "critical"
+	logLevelError    = "error"
+	logLevelWarning  = "warning"
+	logLevelInfo     = "info"
+	logLevelDebug    = "debug"
+	logLevelTrace    = "trace"
+	logLevelUnknown  = "unknown"
+)
+
+// log context defaults, the rows before or after a log line.
+const (
+	defaultLogsContextLimit = 10
+	maxLogsContextLimit     = 1000
+)
+
+// logLevels maps level values, lower case, to grafana log levels. numbers are syslog severities.
+var logLevels = map[string]string{
+	"emerg": logLevelCritical, "emergency": logLevelCritical, "alert": logLevelCritical, "crit": logLevelCritical,
+	"critical": logLevelCritical, "fatal": logLevelCritical, "panic": logLevelCritical,
+	"0": logLevelCritical, "1": logLevelCritical, "2": logLevelCritical,
+	"err": logLevelError, "error": logLevelError, "eror": logLevelError, "severe": logLevelError, "3": logLevelError,
+	"warn": logLevelWarning, "warning": logLevelWarning, "4": logLevelWarning,
+	"notice": logLevelInfo, "info": logLevelInfo, "information": logLevelInfo, "informational": logLevelInfo,
+	"5": logLevelInfo, "6": logLevelInfo,
+	"debug": logLevelDebug, "dbug": logLevelDebug, "fine": logLevelDebug, "7": logLevelDebug,
+	"trace": logLevelTrace, "finer": logLevelTrace, "finest": logLevelTrace,
+}
+
+// logBodyColumns are the columns used as the log line when the body column is not mapped.
+var logBodyColumns = []string{"body", "message", "msg", "line", "log"}
+
+// logsOptions maps the columns of the result of a log query to the parts of the log lines. empty columns are
+// detected: the time, or first timestamp, column, the body, message, msg, line or log column, and the level
+// or severity column. the values of the label columns are the labels of the log lines.
+type logsOptions struct {
+	Time   string   `json:"time,omitempty"`
+	Body   string   `json:"body,omitempty"`
+	Level  string   `json:"level,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	// Volume returns the number of log lines per interval and level instead of the lines, for the log volume histogram
+	Volume bool `json:"volume,omitempty"`
+}
+
+// logsColumns are the columns of a log query result, its custom meta. the context of a log line is read by the time column.
+type logsColumns struct {
+	Time  string `json:"time"`
+	Body  string `json:"body"`
+	Level string `json:"level,omitempty"`
+}
+
+func (o *logsOptions) timeColumn() string {
+	if o == nil || o.Time == "" {
+		return "time"
+	}
+	return o.Time
+}
+
+// volumeStatement wraps statement to count its rows per interval, and per level when the level column is mapped.
+// SELECT TIME_SLICE(<time>, <interval>, 'MILLISECOND') AS time, <level> AS level, COUNT(*) AS count FROM (<statement>) ...
+func (o *logsOptions) volumeStatement(statement string, qm queryModel) string {
+	interval := int64(qm.IntervalMs)
+	if interval <= 0 {
+		interval = qm.To.Sub(qm.From).Milliseconds() / 100
+	}
+	if interval <= 0 {
+		interval = 1
+	}
+	level := "NULL"
+	if o.Level != "" {
+		level = quoteIdentifier(o.Level)
+	}
+	return fmt.Sprintf("SELECT TIME_SLICE(%s, %d, 'MILLISECOND') AS \"time\", %s AS \"level\", COUNT(*) AS \"count\" FROM %s GROUP BY 1, 2 ORDER BY 1",
+		quoteIdentifier(o.timeColumn()), interval, level, subquery(statement, "logs"))
+}
+
+// logLevel maps a level value to a grafana log level, unknown when it is not a known level.
+func logLevel(value string) string {
+	if level, ok := logLevels[strings.ToLower(strings.TrimSpace(value))]; ok {
+		return level
+	}
+	return logLevelUnknown
+}
+
+// logsFrames converts the result of a log query to log frames, a frame for every combination of label values with
+// the labels on its body field. the fields are the time, the body, the level mapped to grafana log levels and the
+// other columns, shown as the fields of a log line.
+func logsFrames(frame *data.Frame, options *logsOptions) ([]*data.Frame, error) {
+	if options == nil {
+		options = &logsOptions{}
+	}
+	columns, err := options.columns(frame)
+	if err != nil {
+		return nil, err
+	}
+	timeField := frameField(frame, columns.Time)
+	bodyField := frameField(frame, columns.Body)
+	var levelField *data.Field
+	if columns.Level != "" {
+		levelField = frameField(frame, columns.Level)
+	}
+	labelFields := make([]*data.Field, len(options.Labels))
+	for i, label := range options.Labels {
+		labelFields[i] = frameField(frame, label)
+	}
+	other := make([]*data.Field, 0, len(frame.Fields))
+	for _, field := range frame.Fields {
+		if field != timeField && field != bodyField && field != levelField && !containsField(labelFields, field) {
+			other = append(other, field)
+		}
+	}
+
+	// the rows and labels of every stream, the label values of a stream joined as its key. a NULL label is left out
+	// of the labels, its stream is another one than the stream of the empty label
+	streams := make(map[string][]int)
+	streamLabels := make(map[string]data.Labels)
+	keys := make([]string, 0)
+	for row := 0; row < frame.Rows(); row++ {
+		var key strings.Builder
+		labels := data.Labels{}
+		for _, field := range labelFields {
+			if value := annotationString(field, row); value != nil {
+				labels[field.Name] = *value
+				key.WriteString("\x01" + *value)
+			}
+			key.WriteString("\x00")
+		}
+		if _, ok := streams[key.String()]; !ok {
+			keys = append(keys, key.String())
+			streamLabels[key.String()] = labels
+		}
+		streams[key.String()] = append(streams[key.String()], row)
+	}
+	if len(keys) == 0 {
+		keys = append(keys, "")
+	}
+
+	frames := make([]*data.Frame, 0, len(keys))
+	for _, key := range keys {
+		rows := streams[key]
+		body := copyRows(bodyField, rows)
+		if len(labelFields) > 0 && len(rows) > 0 {
+			body.Labels = streamLabels[key]
+		}
+		fields := []*data.Field{copyRows(timeField, rows), body}
+		if levelField != nil {
+			levels := make([]*string, len(rows))
+			for i, row := range rows {
+				if value := annotationString(levelField, row); value != nil {
+					level := logLevel(*value)
+					levels[i] = &level
+				}
+			}
+			fields = append(fields, data.NewField("level", nil, levels))
+		}
+		for _, field := range other {
+			fields = append(fields, copyRows(field, rows))
+		}
+		logs := data.NewFrame(frame.Name, fields...)
+		logs.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeLogs})
+		setFrameCustom(logs, "logs", columns)
+		frames = append(frames, logs)
+	}
+	return frames, nil
+}
+
+// columns finds the time, body and level columns of the result of a log query, the mapped columns are required.
+func (o *logsOptions) columns(frame *data.Frame) (logsColumns, error) {
+	var columns logsColumns
+	missing := make([]string, 0)
+	find := func(mapped string, detect func() *data.Field) string {
+		var field *data.Field
+		if mapped != "" {
+			if field = frameField(frame, mapped); field == nil {
+				missing = append(missing, mapped)
+			}
+		} else {
+			field = detect()
+		}
+		if field == nil {
+			return ""
+		}
+		return field.Name
+	}
+	columns.Time = find(o.Time, func() *data.Field {
+		if field := frameField(frame, "time"); field != nil {
+			return field
+		}
+		return firstTimeField(frame)
+	})
+	columns.Level = find(o.Level, func() *data.Field {
+		if field := frameField(frame, "level"); field != nil {
+			return field
+		}
+		return frameField(frame, "severity")
+	})
+	columns.Body = find(o.Body, func() *data.Field {
+		for _, name := range logBodyColumns {
+			if field := frameField(frame, name); field != nil {
+				return field
+			}
+		}
+		// the first string column, which is not the level
+		for _, field := range frame.Fields {
+			if (field.Type() == data.FieldTypeString || field.Type() == data.FieldTypeNullableString) && field.Name != columns.Level {
+				return field
+			}
+		}
+		return nil
+	})
+	for _, label := range o.Labels {
+		if frameField(frame, label) == nil {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		return columns, fmt.Errorf("log query result has no column %s, the columns are %s",
+			strings.Join(missing, ", "), strings.Join(frameColumns(frame), ", "))
+	}
+	if columns.Time == "" {
+		return columns, fmt.Errorf("log query result has no time column, the columns are %s, map the time column", strings.Join(frameColumns(frame), ", "))
+	}
+	if columns.Body == "" {
+		return columns, fmt.Errorf("log query result has no body column, the columns are %s, map the body column", strings.Join(frameColumns(frame), ", "))
+	}
+	return columns, nil
+}
+
+// logsVolumeFrame converts the result of a log volume statement, see volumeStatement, to a frame with the
+// number of log lines of every level per interval, a field for every level labelled with it.
+func logsVolumeFrame(frame *data.Frame) (*data.Frame, error) {
+	timeField := frameField(frame, "time")
+	levelField := frameField(frame, "level")
+	countField := frameField(frame, "count")
+	if timeField == nil || levelField == nil || countField == nil {
+		return nil, errors.New("log volume result has no time, level and count columns")
+	}
+	counts := make(map[string]map[time.Time]int64)
+	times := make([]time.Time, 0)
+	seen := make(map[time.Time]bool)
+	for row := 0; row < frame.Rows(); row++ {
+		start, err := annotationTime(timeField, row)
+		if err != nil {
+			return nil, err
+		}
+		if start == nil {
+			continue
+		}
+		level := logLevelUnknown
+		if value := annotationString(levelField, row); value != nil {
+			level = logLevel(*value)
+		}
+		count, err := countField.FloatAt(row)
+		if err != nil {
+			return nil, err
+		}
+		if counts[level] == nil {
+			counts[level] = make(map[time.Time]int64)
+		}
+		// levels mapping to the same grafana log level are added up
+		counts[level][*start] += int64(count)
+		if !seen[*start] {
+			seen[*start] = true
+			times = append(times, *start)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	levels := make([]string, 0, len(counts))
+	for level := range counts {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	fields := []*data.Field{data.NewField("time", nil, times)}
+	for _, level := range levels {
+		values := make([]int64, len(times))
+		for i, t := range times {
+			values[i] = counts[level][t]
+		}
+		fields = append(fields, data.NewField("count", data.Labels{"level": level}, values))
+	}
+	volume := data.NewFrame(frame.Name, fields...)
+	volume.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeGraph})
+	return volume, nil
+}
+
+// copyRows returns a copy of field with the values of rows.
+func copyRows(field *data.Field, rows []int) *data.Field {
+	copied := data.NewFieldFromFieldType(field.Type(), len(rows))
+	copied.Name = field.Name
+	copied.Labels = field.Labels
+	for i, row := range rows {
+		copied.Set(i, field.CopyAt(row))
+	}
+	return copied
+}
+
+func containsField(fields []*data.Field, field *data.Field) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// logsContextRequest is the body of the /logs/context route, the log query with the time, labels and direction of
+// the log line to read the context of.
+type logsContextRequest struct {
+	queryModel
+	// Range is the time range of the query in epoch milliseconds
+	Range exportRange `json:"range"`
+	// TimeColumn and BodyColumn are the time and body columns of the log frame, from its custom meta
+	TimeColumn string `json:"timeColumn"`
+	BodyColumn string `json:"bodyColumn,omitempty"`
+	Time       int64  `json:"time"`
+	// Line is the body of the log line, which is left out of its context
+	Line   *string           `json:"line,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Direction is BACKWARD for the lines before the log line, FORWARD for the lines after it
+	Direction string `json:"direction,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+// logsContext selects the lines before or after a log line, with the same labels. a nil label is NULL.
+type logsContext struct {
+	timeColumn string
+	time       time.Time
+	bodyColumn string
+	line       *string
+	labels     map[string]*string
+	forward    bool
+	limit      int
+}
+
+// apply wraps statement to return the context of the log line. the time of the line is in milliseconds, the lines
+// within its millisecond are in the context of both directions, except for the line itself.
+// SELECT * FROM (<statement>) AS logs WHERE <time> < <line time + 1ms> AND NOT (<time> >= <line time> AND <time> < <line time + 1ms>
+// AND <body> = <line>) AND <label> = <value> ORDER BY <time> DESC LIMIT <limit>
+func (c *logsContext) apply(statement string) string {
+	timeColumn := quoteIdentifier(c.timeColumn)
+	start := quoteLiteral(c.time.UTC().Format("2006-01-02 15:04:05.999999-07")) + "::TIMESTAMPTZ"
+	end := quoteLiteral(c.time.Add(time.Millisecond).UTC().Format("2006-01-02 15:04:05.999999-07")) + "::TIMESTAMPTZ"
+	condition, direction := fmt.Sprintf("%s < %s", timeColumn, end), "DESC"
+	if c.forward {
+		condition, direction = fmt.Sprintf("%s >= %s", timeColumn, start), "ASC"
+	}
+	conditions := []string{condition}
+	if c.bodyColumn != "" && c.line != nil {
+		conditions = append(conditions, fmt.Sprintf("NOT (%s >= %s AND %s < %s AND %s IS NOT NULL AND %s = %s)",
+			timeColumn, start, timeColumn, end, quoteIdentifier(c.bodyColumn), quoteIdentifier(c.bodyColumn), quoteLiteral(*c.line)))
+	}
+	labels := make([]string, 0, len(c.labels))
+	for label := range c.labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if value := c.labels[label]; value != nil {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", quoteIdentifier(label), quoteLiteral(*value)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%s IS NULL", quoteIdentifier(label)))
+		}
+	}
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY %s %s LIMIT %d",
+		subquery(statement, "logs"), strings.Join(conditions, " AND "), timeColumn, direction, c.limit)
+}
+
+// handleLogsContext returns the log lines before or after a log line as log frames, encoded like the frames of a
+// query response.
+func (td *VerticaDatasource) handleLogsContext(w http.ResponseWriter, r *http.Request) {
+	rc, instance, err := td.resourceRequest(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var req logsContextRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	qm := req.queryModel
+	qm.Pagination = nil
+	qm.QueryType = queryTypeLogs
+	if qm.Logs != nil {
+		logs := *qm.Logs
+		logs.Volume = false
+		qm.Logs = &logs
+	}
+	if req.Range.From > 0 && req.Range.To > 0 {
+		qm.From = time.Unix(0, req.Range.From*int64(time.Millisecond))
+		qm.To = time.Unix(0, req.Range.To*int64(time.Millisecond))
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultLogsContextLimit
+	}
+	if limit > maxLogsContextLimit {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("logs context limit %d is larger than %d", limit, maxLogsContextLimit))
+		return
+	}
+	timeColumn := req.TimeColumn
+	if timeColumn == "" {
+		timeColumn = qm.Logs.timeColumn()
+	}
+	// only the label columns of the query filter the context, a label the line has not got is NULL
+	labels := make(map[string]*string)
+	if qm.Logs != nil {
+		for _, label := range qm.Logs.Labels {
+			labels[label] = nil
+			for name, value := range req.Labels {
+				if strings.EqualFold(name, label) {
+					value := value
+					labels[label] = &value
+				}
+			}
+		}
+	}
+	qm.logsContext = &logsContext{timeColumn: timeColumn, time: time.Unix(0, req.Time*int64(time.Millisecond)),
+		bodyColumn: req.BodyColumn, line: req.Line, labels: labels, forward: strings.EqualFold(req.Direction, "FORWARD"), limit: limit}
+	refID := qm.RefId
+	if refID == "" {
+		refID = "Logs"
+	}
+	frames, status, err := instance.logsContextFrames(r.Context(), rc, qm, refID)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	encoded := make([][]byte, len(frames))
+	for i, frame := range frames {
+		if encoded[i], err = frame.MarshalArrow(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": map[string]interface{}{refID: map[string]interface{}{"frames": encoded}},
+	})
+}
+
+// logsContextFrames runs the context statement of a log query and returns its result as log frames.
+func (s *instanceSettings) logsContextFrames(ctx context.Context, rc requestContext, qm queryModel, refID string) ([]*data.Frame, int, error) {
+	var err error
+	observation := observeQuery(s.UID, "LogsContext")
+	defer func() {
+		observation.done(err)
+		s.logQuery(rc, refID, observation, err)
+	}()
+	prepared, err := s.prepareQuery(rc, qm, refID)
+	if err != nil {
+		observation.step = failedStep(err, "prepareQuery")
+		return nil, http.StatusBadRequest, err
+	}
+	if err = prepared.readOnly(); err != nil {
+		observation.step = "readOnly"
+		return nil, http.StatusForbidden, err
+	}
+	observation.stage = errorClassQuery
+	observation.statement = prepared.statement
+	observation.args = prepared.args
+	connection, rows, err := prepared.run(ctx, prepared.statement, &observation.timing)
+	if err != nil {
+		observation.step = "queryContext"
+		return nil, http.StatusBadRequest, err
+	}
+	defer s.releasePrepared(connection, prepared)
+	defer rows.Close()
+	fetchStart := time.Now()
+	frame, step, err := scanFrame(rows, refID, observation)
+	observation.timing.Fetch = time.Since(fetchStart)
+	if err != nil {
+		observation.step = step
+		return nil, http.StatusBadRequest, err
+	}
+	if frame == nil {
+		frame = data.NewFrame(refID)
+	}
+	observation.stage = errorClassConversion
+	frames, err := logsFrames(frame, qm.Logs)
+	if err != nil {
+		observation.step = "logs"
+		return nil, http.StatusBadRequest, err
+	}
+	return frames, http.StatusOK, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestLogsColumns(t *testing.T) {
+	at := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name    string
+		fields  []*data.Field
+		options logsOptions
+		want    logsColumns
+		err     string
+	}{
+		{
+			name:   "named columns",
+			fields: []*data.Field{data.NewField("host", nil, []string{"a"}), data.NewField("Time", nil, []time.Time{at}), data.NewField("severity", nil, []string{"warn"}), data.NewField("msg", nil, []string{"m"})},
+			want:   logsColumns{Time: "Time", Body: "msg", Level: "severity"},
+		},
+		{
+			name:   "first time and string column",
+			fields: []*data.Field{data.NewField("level", nil, []string{"info"}), data.NewField("created", nil, []*time.Time{&at}), data.NewField("text", nil, []*string{nil})},
+			want:   logsColumns{Time: "created", Body: "text", Level: "level"},
+		},
+		{
+			name:    "mapped columns",
+			fields:  []*data.Field{data.NewField("at", nil, []time.Time{at}), data.NewField("message", nil, []string{"m"}), data.NewField("payload", nil, []string{"p"})},
+			options: logsOptions{Time: "at", Body: "PAYLOAD"},
+			want:    logsColumns{Time: "at", Body: "payload"},
+		},
+		{
+			name:    "missing mapped columns",
+			fields:  []*data.Field{data.NewField("time", nil, []time.Time{at}), data.NewField("body", nil, []string{"m"})},
+			options: logsOptions{Level: "lvl", Labels: []string{"host"}},
+			err:     "no column lvl, host, the columns are time, body",
+		},
+		{
+			name:   "no time column",
+			fields: []*data.Field{data.NewField("body", nil, []string{"m"})},
+			err:    "no time column",
+		},
+		{
+			name:   "no body column",
+			fields: []*data.Field{data.NewField("time", nil, []time.Time{at}), data.NewField("level", nil, []string{"info"})},
+			err:    "no body column",
+		},
+	} {
+		got, err := test.options.columns(data.NewFrame("A", test.fields...))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got the error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: got the columns %+v, %v, want %+v", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	for value, want := range map[string]string{
+		"EMERG":   logLevelCritical,
+		"fatal":   logLevelCritical,
+		"2":       logLevelCritical,
+		"err":     logLevelError,
+		" Error ": logLevelError,
+		"3":       logLevelError,
+		"WARN":    logLevelWarning,
+		"notice":  logLevelInfo,
+		"6":       logLevelInfo,
+		"7":       logLevelDebug,
+		"finest":  logLevelTrace,
+		"8":       logLevelUnknown,
+		"":        logLevelUnknown,
+		"verbose": logLevelUnknown,
+	} {
+		if got := logLevel(value); got != want {
+			t.Errorf("logLevel(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestLogsFramesStreams(t *testing.T) {
+	at := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	empty, api := "", "api"
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{at, at.Add(time.Second), at.Add(2 * time.Second), at.Add(3 * time.Second)}),
+		data.NewField("body", nil, []string{"one", "two", "three", "four"}),
+		data.NewField("level", nil, []string{"err", "7", "warn", "verbose"}),
+		data.NewField("service", nil, []*string{&api, nil, &empty, &api}),
+		data.NewField("pid", nil, []int64{1, 2, 3, 4}),
+	)
+	frames, err := logsFrames(frame, &logsOptions{Labels: []string{"service"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the NULL service and the empty service are separate streams
+	if len(frames) != 3 {
+		t.Fatalf("got %d streams, want 3", len(frames))
+	}
+	for i, want := range []struct {
+		labels data.Labels
+		bodies []string
+		levels []string
+		pids   []int64
+	}{
+		{data.Labels{"service": "api"}, []string{"one", "four"}, []string{logLevelError, logLevelUnknown}, []int64{1, 4}},
+		{data.Labels{}, []string{"two"}, []string{logLevelDebug}, []int64{2}},
+		{data.Labels{"service": ""}, []string{"three"}, []string{logLevelWarning}, []int64{3}},
+	} {
+		stream := frames[i]
+		names := make([]string, len(stream.Fields))
+		for j, field := range stream.Fields {
+			names[j] = field.Name
+		}
+		if !reflect.DeepEqual(names, []string{"time", "body", "level", "pid"}) {
+			t.Fatalf("stream %d has the fields %v", i, names)
+		}
+		if !reflect.DeepEqual(stream.Fields[1].Labels, want.labels) {
+			t.Errorf("stream %d has the labels %v, want %v", i, stream.Fields[1].Labels, want.labels)
+		}
+		bodies := make([]string, stream.Rows())
+		levels := make([]string, stream.Rows())
+		pids := make([]int64, stream.Rows())
+		for row := range bodies {
+			bodies[row] = stream.Fields[1].At(row).(string)
+			levels[row] = *stream.Fields[2].At(row).(*string)
+			pids[row] = stream.Fields[3].At(row).(int64)
+		}
+		if !reflect.DeepEqual(bodies, want.bodies) || !reflect.DeepEqual(levels, want.levels) || !reflect.DeepEqual(pids, want.pids) {
+			t.Errorf("stream %d has the lines %q, levels %q and pids %v, want %q, %q and %v", i, bodies, levels, pids, want.bodies, want.levels, want.pids)
+		}
+		if stream.Meta == nil || stream.Meta.PreferredVisualization != data.VisTypeLogs {
+			t.Errorf("stream %d is not shown as logs", i)
+		}
+	}
+}
+
+func TestLogsVolumeFrame(t *testing.T) {
+	at := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	later := at.Add(time.Minute)
+	err1, error1, info := "err", "error", "info"
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []*time.Time{&later, &at, &at, &later, nil}),
+		data.NewField("level", nil, []*string{&err1, &error1, &info, nil, &info}),
+		data.NewField("count", nil, []int64{5, 2, 3, 7, 100}),
+	)
+	volume, err := logsVolumeFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := volume.Fields[0].Len(); got != 2 || !volume.Fields[0].At(0).(time.Time).Equal(at) {
+		t.Fatalf("the volume has %d times starting at %v, want 2 starting at %v", got, volume.Fields[0].At(0), at)
+	}
+	// err and error add up, a NULL level is unknown and a row without a time is left out
+	want := map[string][]int64{logLevelError: {2, 5}, logLevelInfo: {3, 0}, logLevelUnknown: {0, 7}}
+	got := make(map[string][]int64)
+	for _, field := range volume.Fields[1:] {
+		got[field.Labels["level"]] = []int64{field.At(0).(int64), field.At(1).(int64)}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the volume is %v, want %v", got, want)
+	}
+
+	if _, err := logsVolumeFrame(data.NewFrame("A", data.NewField("time", nil, []time.Time{at}))); err == nil {
+		t.Error("a volume without level and count columns was accepted")
+	}
+}
+
+func TestLogsVolumeStatement(t *testing.T) {
+	qm := queryModel{IntervalMs: 60000}
+	got := (&logsOptions{Level: "severity"}).volumeStatement("SELECT * FROM logs;", qm)
+	want := `SELECT TIME_SLICE("time", 60000, 'MILLISECOND') AS "time", "severity" AS "level", COUNT(*) AS "count" FROM (
+SELECT * FROM logs
+) AS logs GROUP BY 1, 2 ORDER BY 1`
+	if got != want {
+		t.Errorf("the volume statement is\n%s\nwant\n%s", got, want)
+	}
+	qm = queryModel{From: time.Unix(0, 0), To: time.Unix(1000, 0)}
+	if got := (&logsOptions{Time: "ts"}).volumeStatement("SELECT 1", qm); !strings.HasPrefix(got, `SELECT TIME_SLICE("ts", 10000, 'MILLISECOND') AS "time", NULL AS "level"`) {
+		t.Errorf("the volume statement without interval and level is %s", got)
+	}
+}
+
+func TestLogsContextStatement(t *testing.T) {
+	api := "api's"
+	line := "it's down"
+	context := logsContext{
+		timeColumn: "time",
+		time:       time.Date(2021, 6, 1, 0, 0, 1, 500*int(time.Millisecond), time.UTC),
+		bodyColumn: "body",
+		line:       &line,
+		labels:     map[string]*string{"service": &api, "host": nil},
+		limit:      10,
+	}
+	anchor := `NOT ("time" >= '2021-06-01 00:00:01.5+00'::TIMESTAMPTZ AND "time" < '2021-06-01 00:00:01.501+00'::TIMESTAMPTZ AND "body" IS NOT NULL AND "body" = 'it''s down')`
+	labels := `"host" IS NULL AND "service" = 'api''s'`
+	for forward, want := range map[bool]string{
+		false: `SELECT * FROM (
+SELECT * FROM logs
+) AS logs WHERE "time" < '2021-06-01 00:00:01.501+00'::TIMESTAMPTZ AND ` + anchor + ` AND ` + labels + ` ORDER BY "time" DESC LIMIT 10`,
+		true: `SELECT * FROM (
+SELECT * FROM logs
+) AS logs WHERE "time" >= '2021-06-01 00:00:01.5+00'::TIMESTAMPTZ AND ` + anchor + ` AND ` + labels + ` ORDER BY "time" ASC LIMIT 10`,
+	} {
+		context.forward = forward
+		if got := context.apply("SELECT * FROM logs;"); got != want {
+			t.Errorf("the context statement, forward %v, is\n%s\nwant\n%s", forward, got, want)
+		}
+	}
+
+	// without the body of the line the lines of its millisecond are all in the context
+	context = logsContext{timeColumn: "ts", time: time.Unix(0, 0), limit: 5}
+	if got := context.apply("SELECT 1"); strings.Contains(got, "NOT") || !strings.Contains(got, `WHERE "ts" < '1970-01-01 00:00:00.001+00'::TIMESTAMPTZ ORDER BY`) {
+		t.Errorf("the context statement without a line is %s", got)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are registered with the default prometheus registry, which the plugin sdk exposes
+// on the plugin metrics endpoint of grafana.
+const metricsNamespace = "vertica_datasource"
+
+// poolCollector collects the connection pool statistics of every data source instance.
+// instances add themselves when created and remove themselves when disposed.
+type poolCollector struct {
+	mtx   sync.Mutex
+	pools map[*sql.DB]poolLabels
+
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	maxOpen      *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+// poolLabels identify the pool of a data source instance in the pool metrics.
+type poolLabels struct {
+	Datasource    string
+	DatasourceUID string
+	Pool          string
+}
+
+func (l poolLabels) values() []string {
+	return []string{l.Datasource, l.DatasourceUID, l.Pool}
+}
+
+func newPoolCollector() *poolCollector {
+	labels := []string{"datasource", "datasource_uid", "pool"}
+	return &poolCollector{
+		pools:        make(map[*sql.DB]poolLabels),
+		open:         prometheus.NewDesc(metricsNamespace+"_pool_open_connections", "Number of established connections, in use and idle.", labels, nil),
+		inUse:        prometheus.NewDesc(metricsNamespace+"_pool_in_use_connections", "Number of connections currently in use.", labels, nil),
+		idle:         prometheus.NewDesc(metricsNamespace+"_pool_idle_connections", "Number of idle connections.", labels, nil),
+		maxOpen:      prometheus.NewDesc(metricsNamespace+"_pool_max_open_connections", "Maximum number of open connections.", labels, nil),
+		waitCount:    prometheus.NewDesc(metricsNamespace+"_pool_wait_count_total", "Total number of connections waited for.", labels, nil),
+		waitDuration: prometheus.NewDesc(metricsNamespace+"_pool_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", labels, nil),
+	}
+}
+
+// poolStats is the collector of the pool metrics.
+var poolStats = newPoolCollector()
+
+func init() {
+	prometheus.MustRegister(poolStats)
+}
+
+func (c *poolCollector) add(db *sql.DB, labels poolLabels) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pools[db] = labels
+}
+
+func (c *poolCollector) remove(db *sql.DB) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.pools, db)
+}
+
+// Describe implements prometheus.Collector.
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.maxOpen
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+// Collect implements prometheus.Collector.
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for db, labels := range c.pools {
+		// https://golang.org/pkg/database/sql/#DBStats
+		stats := db.Stats()
+		values := labels.values()
+		ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections), values...)
+		ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse), values...)
+		ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle), values...)
+		ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections), values...)
+		ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount), values...)
+		ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds(), values...)
+	}
+}
+
+// query metrics, labelled with the data source uid and the format of the query.
+var (
+	queryLabelNames = []string{"datasource_uid", "format"}
+
+	queriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queries_total",
+		Help:      "Total number of queries executed.",
+	}, queryLabelNames)
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_duration_seconds",
+		Help:      "Duration of the phases of a query: connect (connection and session setup), execute and fetch.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, append(queryLabelNames, "phase"))
+	queryRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_rows_total",
+		Help:      "Total number of rows returned by queries.",
+	}, queryLabelNames)
+	queryBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_bytes_total",
+		Help:      "Approximate total size of the values returned by queries.",
+	}, queryLabelNames)
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_errors_total",
+		Help:      "Total number of failed queries by error class.",
+	}, append(queryLabelNames, "class"))
+	activeQueries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "active_queries",
+		Help:      "Number of queries currently running.",
+	}, queryLabelNames)
+	cacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_hits_total",
+		Help:      "Total number of lookups answered from a cache of the plugin.",
+	}, []string{"datasource_uid", "cache"})
+	cacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_misses_total",
+		Help:      "Total number of lookups a cache of the plugin could not answer.",
+	}, []string{"datasource_uid", "cache"})
+	cursorPages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cursor_pages_total",
+		Help:      "Total number of pages read from server side cursors.",
+	}, []string{"datasource_uid"})
+)
+
+func init() {
+	prometheus.MustRegister(queriesTotal, queryDuration, queryRows, queryBytes, queryErrors, activeQueries, cacheHits, cacheMisses, cursorPages)
+}
+
+// caches of the cache metrics: the connection pools of workloads and forwarded user identities, and the oauth
+// access token of the client credentials grant.
+const (
+	cachePool  = "pool"
+	cacheToken = "token"
+)
+
+// observeCache counts a lookup of cache as a hit or a miss.
+func observeCache(datasourceUID, cache string, hit bool) {
+	if hit {
+		cacheHits.WithLabelValues(datasourceUID, cache).Inc()
+	} else {
+		cacheMisses.WithLabelValues(datasourceUID, cache).Inc()
+	}
+}
+
+// metricFormats are the values of the format label, the formats of panel queries and the resource routes running
+// queries. the format of a panel query comes from the request, others are counted as other to bound the label values.
+var metricFormats = map[string]bool{
+	"Table":              true,
+	"Time Series":        true,
+	queryTypeLogs:        true,
+	queryTypeAnnotations: true,
+	"Cursor":             true,
+	"Export":             true,
+	"LogsContext":        true,
+}
+
+// metricFormat returns the format label of a query format.
+func metricFormat(format string) string {
+	if format == "" {
+		return "Table"
+	}
+	if !metricFormats[format] {
+		return "other"
+	}
+	return format
+}
+
+// error classes of the query error metric.
+const (
+	errorClassRequest    = "request"
+	errorClassConnection = "connection"
+	errorClassTimeout    = "timeout"
+	errorClassCanceled   = "canceled"
+	errorClassQuery      = "query"
+	errorClassConversion = "conversion"
+)
+
+// queryTiming holds the time spent in the phases of a query.
+type queryTiming struct {
+	Connect time.Duration
+	Execute time.Duration
+	Fetch   time.Duration
+}
+
+// queryObservation records the metrics of a single query, from start to done.
+type queryObservation struct {
+	labels []string
+	start  time.Time
+	timing queryTiming
+	rows   int
+	bytes  int
+	// stage is the error class of an error returned by the current step of the query
+	stage string
+	// step names the step of the query which failed, for the logs
+	step string
+	// statement is the sql sent to vertica, empty until the query is executed
+	statement string
+	// args are the values of the bind parameters of statement
+	args []interface{}
+	ids  statementIDs
+}
+
+// observeQuery counts a query as started and active.
+func observeQuery(datasourceUID, format string) *queryObservation {
+	o := &queryObservation{labels: []string{datasourceUID, metricFormat(format)}, start: time.Now(), stage: errorClassRequest}
+	queriesTotal.WithLabelValues(o.labels...).Inc()
+	activeQueries.WithLabelValues(o.labels...).Inc()
+	return o
+}
+
+// scanned adds a scanned row to the returned rows and bytes.
+func (o *queryObservation) scanned(row []interface{}) {
+	o.rows++
+	for _, value := range row {
+		o.bytes += valueSize(value)
+	}
+}
+
+// done records the timing, rows and bytes of the query and the class of err, if any.
+func (o *queryObservation) done(err error) {
+	activeQueries.WithLabelValues(o.labels...).Dec()
+	for phase, duration := range map[string]time.Duration{
+		"connect": o.timing.Connect,
+		"execute": o.timing.Execute,
+		"fetch":   o.timing.Fetch,
+	} {
+		if duration > 0 {
+			queryDuration.WithLabelValues(append(o.labels, phase)...).Observe(duration.Seconds())
+		}
+	}
+	queryRows.WithLabelValues(o.labels...).Add(float64(o.rows))
+	queryBytes.WithLabelValues(o.labels...).Add(float64(o.bytes))
+	if err != nil {
+		queryErrors.WithLabelValues(append(o.labels, errorClass(err, o.stage))...).Inc()
+	}
+}
+
+// errorClass returns the class of a query error, stage is the class used when the error itself does not tell.
+func errorClass(err error, stage string) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errorClassTimeout
+	case isConnectionError(err):
+		return errorClassConnection
+	}
+	return stage
+}
+
+// valueSize returns the approximate size in bytes of a value scanned by generateRowIn, see scannedValue for NULL values.
+func valueSize(value interface{}) int {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case *string:
+		return len(*v)
+	case *bool:
+		return 1
+	case *time.Time:
+		return 12
+	default:
+		return 8
+	}
+}
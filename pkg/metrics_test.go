@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricFormat(t *testing.T) {
+	for format, want := range map[string]string{
+		"":                  "Table",
+		"Time Series":       "Time Series",
+		"Logs":              "Logs",
+		"Export":            "Export",
+		"time series":       "other",
+		"' OR 1=1 --":       "other",
+		"Table\nwith space": "other",
+	} {
+		if got := metricFormat(format); got != want {
+			t.Errorf("metricFormat(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestTokenCacheMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+	}))
+	defer server.Close()
+	provider := &clientCredentialsToken{httpClient: server.Client(), config: oauthConfig{TokenURL: server.URL}, datasourceUID: "cache-test"}
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Credentials(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hits := testutil.ToFloat64(cacheHits.WithLabelValues("cache-test", cacheToken))
+	misses := testutil.ToFloat64(cacheMisses.WithLabelValues("cache-test", cacheToken))
+	if hits != 2 || misses != 1 {
+		t.Errorf("3 token lookups counted %v hits and %v misses, want 2 hits and 1 miss", hits, misses)
+	}
+}
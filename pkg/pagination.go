@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryPagination returns a page of the result of a query instead of all of it. pages are chosen by offset, which
+// needs an ORDER BY in the query, or with a keyset: the rows ordered by Key after the value After, which stays fast
+// for pages deep in the result. the keyset has to be unique, a TieBreaker column makes the order of rows with the
+// same Key unique.
+type queryPagination struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset,omitempty"`
+	// Key is the column the rows are ordered by, After the value of Key on the last row of the previous page
+	Key        string `json:"key,omitempty"`
+	After      string `json:"after,omitempty"`
+	Descending bool   `json:"descending,omitempty"`
+	// TieBreaker orders the rows with the same Key, AfterTieBreaker is its value on the last row of the previous page
+	TieBreaker      string `json:"tieBreaker,omitempty"`
+	AfterTieBreaker string `json:"afterTieBreaker,omitempty"`
+	// Count counts the rows of all pages on every page, not only on the first one
+	Count bool `json:"count,omitempty"`
+}
+
+// pageInfo is added to the frames of a paginated query as the pagination custom meta.
+type pageInfo struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// Total is the number of rows of the query without pagination, missing on later pages and when counting failed
+	Total *int64 `json:"total,omitempty"`
+	// Next is the value of Key on the last row, the After of the next page, empty without a key or on the last page
+	Next string `json:"next,omitempty"`
+	// NextTieBreaker is the value of the TieBreaker on the last row, the AfterTieBreaker of the next page
+	NextTieBreaker string `json:"nextTieBreaker,omitempty"`
+}
+
+// orderBy matches the ORDER BY keywords of a statement, rowLimit its LIMIT and OFFSET keywords.
+var (
+	orderBy  = regexp.MustCompile(`(?i)\border\s+by\b`)
+	rowLimit = regexp.MustCompile(`(?i)\b(limit|offset)\b`)
+)
+
+// ordered tells whether statement orders its rows, with an ORDER BY outside of parentheses. the ORDER BY of a
+// window or a subquery does not order the result.
+func ordered(statement string) bool {
+	return topLevel(statement, orderBy)
+}
+
+// topLevel tells whether keyword matches statement outside of parentheses, string literals and comments.
+func topLevel(statement string, keyword *regexp.Regexp) bool {
+	stripped := stripComments(redactLiterals(statement))
+	for _, match := range keyword.FindAllStringIndex(stripped, -1) {
+		before := stripped[:match[0]]
+		if strings.Count(before, "(") == strings.Count(before, ")") {
+			return true
+		}
+	}
+	return false
+}
+
+// firstPage tells whether the pagination reads the first page of the result.
+func (p queryPagination) firstPage() bool {
+	return p.Offset == 0 && p.After == ""
+}
+
+// apply returns the statement of the page, and the statement counting the rows of all pages, empty when the page
+// is not counted. offset pages limit the ordered statement itself, <statement> LIMIT <limit> OFFSET <offset>, an
+// ORDER BY inside of a wrapping statement would not order its LIMIT. keyset pages wrap it,
+// SELECT * FROM (<statement>) AS page WHERE <key> > <after> ORDER BY <key> LIMIT <limit> OFFSET <offset>.
+func (p queryPagination) apply(statement string) (string, string, error) {
+	if p.Limit <= 0 {
+		return "", "", fmt.Errorf("pagination limit %d has to be positive", p.Limit)
+	}
+	if p.Offset < 0 {
+		return "", "", fmt.Errorf("pagination offset %d is negative", p.Offset)
+	}
+	var page string
+	if p.Key == "" {
+		// without an order the rows of a page can change from run to run, the pages would miss and repeat rows
+		if !ordered(statement) {
+			return "", "", errors.New("offset pagination needs an ORDER BY in the query for a stable order of the rows, or a key column to page by")
+		}
+		if topLevel(statement, rowLimit) {
+			return "", "", errors.New("offset pagination of a query with a LIMIT or OFFSET of its own is not supported, page by a key column")
+		}
+		// the limit goes on its own line, after a trailing line comment
+		page = trimStatement(statement) + "\n"
+	} else {
+		if p.TieBreaker != "" && p.After != "" && p.AfterTieBreaker == "" {
+			return "", "", errors.New("keyset pagination with a tie breaker needs the tie breaker of the last row with after")
+		}
+		page = "SELECT * FROM " + subquery(statement, "page") + " " + p.keyset() + " "
+	}
+	page += fmt.Sprintf("LIMIT %d", p.Limit)
+	if p.Offset > 0 {
+		page += fmt.Sprintf(" OFFSET %d", p.Offset)
+	}
+	// counting runs the query again, the total does not change between pages
+	if !p.firstPage() && !p.Count {
+		return page, "", nil
+	}
+	count := "SELECT COUNT(*) FROM " + subquery(statement, "total")
+	return page, count, nil
+}
+
+// keyset returns the condition and order of a keyset page. with a tie breaker the rows after the last row of the
+// previous page are those with a later key, or with the same key and a later tie breaker.
+func (p queryPagination) keyset() string {
+	key := quoteIdentifier(p.Key)
+	comparison, direction := ">", "ASC"
+	if p.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	after := quoteLiteral(p.After)
+	condition := fmt.Sprintf("%s %s %s", key, comparison, after)
+	order := fmt.Sprintf("%s %s", key, direction)
+	if p.TieBreaker != "" {
+		tieBreaker := quoteIdentifier(p.TieBreaker)
+		condition = fmt.Sprintf("(%s OR (%s = %s AND %s %s %s))", condition, key, after, tieBreaker, comparison, quoteLiteral(p.AfterTieBreaker))
+		order += fmt.Sprintf(", %s %s", tieBreaker, direction)
+	}
+	if p.After == "" {
+		return "ORDER BY " + order
+	}
+	return "WHERE " + condition + " ORDER BY " + order
+}
+
+// pageInfo counts the rows of all pages on the connection of the query, unless count is empty, and reads the key
+// of the next page from frame.
+func (p queryPagination) pageInfo(ctx context.Context, connection *sql.Conn, count string, args []interface{}, frame *data.Frame) (pageInfo, error) {
+	info := pageInfo{Limit: p.Limit, Offset: p.Offset}
+	if p.Key != "" && frame.Rows() == p.Limit {
+		if field := frameField(frame, p.Key); field != nil {
+			info.Next = valueString(field.At(frame.Rows() - 1))
+		}
+		if field := frameField(frame, p.TieBreaker); field != nil && p.TieBreaker != "" {
+			info.NextTieBreaker = valueString(field.At(frame.Rows() - 1))
+		}
+	}
+	if count == "" {
+		return info, nil
+	}
+	var total int64
+	if err := connection.QueryRowContext(ctx, count, args...).Scan(&total); err != nil {
+		return info, err
+	}
+	info.Total = &total
+	return info, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestOrdered(t *testing.T) {
+	for statement, want := range map[string]bool{
+		"SELECT * FROM t ORDER BY a":                               true,
+		"select *\nfrom t\norder  by a desc;":                      true,
+		"SELECT * FROM t":                                          false,
+		"SELECT ROW_NUMBER() OVER (ORDER BY a) FROM t":             false,
+		"SELECT * FROM (SELECT * FROM t ORDER BY a) AS s":          false,
+		"SELECT * FROM t WHERE b = 'order by' -- order by a":       false,
+		"SELECT * FROM t /* ORDER BY a */":                         false,
+		"SELECT a FROM (SELECT a FROM t) AS s ORDER BY a LIMIT 10": true,
+	} {
+		if got := ordered(statement); got != want {
+			t.Errorf("ordered(%q) = %v, want %v", statement, got, want)
+		}
+	}
+}
+
+func TestPaginationApply(t *testing.T) {
+	if _, _, err := (queryPagination{Limit: 10}).apply("SELECT * FROM t"); err == nil || !strings.Contains(err.Error(), "ORDER BY") {
+		t.Errorf("offset pagination of an unordered query returned %v, want the ORDER BY error", err)
+	}
+	if _, _, err := (queryPagination{Limit: 10, Key: "id"}).apply("SELECT * FROM t"); err != nil {
+		t.Errorf("keyset pagination of an unordered query failed: %v", err)
+	}
+	for _, p := range []queryPagination{
+		{Limit: 10},
+		{Limit: 10, Offset: 10, Count: true},
+		{Limit: 10, Key: "id"},
+	} {
+		if _, count, err := p.apply("SELECT * FROM t ORDER BY id"); err != nil || !strings.HasPrefix(count, "SELECT COUNT(*)") {
+			t.Errorf("%+v returned the count %q and %v, want the count statement", p, count, err)
+		}
+	}
+	for _, p := range []queryPagination{
+		{Limit: 10, Offset: 10},
+		{Limit: 10, Key: "id", After: "5"},
+	} {
+		if _, count, err := p.apply("SELECT * FROM t ORDER BY id"); err != nil || count != "" {
+			t.Errorf("%+v returned the count %q and %v, a later page is not counted", p, count, err)
+		}
+	}
+}
+
+func TestPaginationStatements(t *testing.T) {
+	for _, test := range []struct {
+		pagination queryPagination
+		statement  string
+		want       string
+	}{
+		// offset pages limit the ordered statement itself, the order of a subquery would not order the limit
+		{queryPagination{Limit: 10}, "SELECT * FROM t ORDER BY a;",
+			"SELECT * FROM t ORDER BY a\nLIMIT 10"},
+		{queryPagination{Limit: 10, Offset: 20}, "SELECT * FROM t ORDER BY a -- by a",
+			"SELECT * FROM t ORDER BY a -- by a\nLIMIT 10 OFFSET 20"},
+		// a unique key orders the page by itself
+		{queryPagination{Limit: 10, Key: "id", After: "5"}, "SELECT * FROM t",
+			"SELECT * FROM (\nSELECT * FROM t\n) AS page WHERE \"id\" > '5' ORDER BY \"id\" ASC LIMIT 10"},
+		{queryPagination{Limit: 10, Key: "id", Descending: true}, "SELECT * FROM t",
+			"SELECT * FROM (\nSELECT * FROM t\n) AS page ORDER BY \"id\" DESC LIMIT 10"},
+		// a key with ties needs a tie breaker, the rows with the key of the last row continue after its tie breaker
+		{queryPagination{Limit: 10, Key: "day", TieBreaker: "id", After: "2021-06-01", AfterTieBreaker: "7"}, "SELECT * FROM t",
+			"SELECT * FROM (\nSELECT * FROM t\n) AS page WHERE (\"day\" > '2021-06-01' OR (\"day\" = '2021-06-01' AND \"id\" > '7')) ORDER BY \"day\" ASC, \"id\" ASC LIMIT 10"},
+		{queryPagination{Limit: 10, Key: "day", TieBreaker: "id", Descending: true}, "SELECT * FROM t",
+			"SELECT * FROM (\nSELECT * FROM t\n) AS page ORDER BY \"day\" DESC, \"id\" DESC LIMIT 10"},
+	} {
+		page, _, err := test.pagination.apply(test.statement)
+		if err != nil || page != test.want {
+			t.Errorf("%+v of %q returned %q, %v, want %q", test.pagination, test.statement, page, err, test.want)
+		}
+	}
+	if _, _, err := (queryPagination{Limit: 10}).apply("SELECT * FROM t ORDER BY a LIMIT 100"); err == nil {
+		t.Error("offset pagination of a query with a LIMIT of its own was accepted")
+	}
+	if _, _, err := (queryPagination{Limit: 10}).apply("SELECT * FROM (SELECT * FROM t LIMIT 100) AS s ORDER BY a"); err != nil {
+		t.Errorf("offset pagination of a query with a LIMIT in a subquery failed: %v", err)
+	}
+	if _, _, err := (queryPagination{Limit: 10, Key: "day", TieBreaker: "id", After: "2021-06-01"}).apply("SELECT * FROM t"); err == nil {
+		t.Error("a keyset page with a tie breaker but without the tie breaker of the last row was accepted")
+	}
+}
+
+func TestPaginationNextTieBreaker(t *testing.T) {
+	day := "2021-06-01"
+	frame := data.NewFrame("A",
+		data.NewField("day", nil, []*string{&day, &day}),
+		data.NewField("id", nil, []int64{6, 7}),
+	)
+	info, err := (queryPagination{Limit: 2, Key: "DAY", TieBreaker: "id"}).pageInfo(context.Background(), nil, "", nil, frame)
+	if err != nil || info.Next != day || info.NextTieBreaker != "7" {
+		t.Errorf("the page info is %+v, %v, want next %s and the tie breaker 7", info, err, day)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// built in parameters of queries with bind parameters, the time range and interval of the query.
+const (
+	parameterFrom       = "__from"
+	parameterTo         = "__to"
+	parameterIntervalMs = "__interval_ms"
+)
+
+// bindParameters rewrites the $name, ${name} and ${name:format} references of statement to ? placeholders and
+// returns the values to bind to them, in order. a parameter with several values, a multi-value variable, is
+// expanded to one placeholder per value after IN, in parentheses: host IN $host becomes host IN (?, ?, ?).
+// without prepared statements the values are written into the statement as literals instead of placeholders.
+// references in string literals, quoted identifiers, comments and dollar quoted strings, and references to names
+// which are no parameter, are left alone.
+func (qm queryModel) bindParameters(statement string, prepared bool) (string, []interface{}, error) {
+	var out strings.Builder
+	args := make([]interface{}, 0)
+	for i := 0; i < len(statement); {
+		if end := skipQuoted(statement, i); end > i {
+			out.WriteString(statement[i:end])
+			i = end
+			continue
+		}
+		if statement[i] != '$' {
+			out.WriteByte(statement[i])
+			i++
+			continue
+		}
+		name, end := parameterReference(statement, i)
+		if name == "" {
+			out.WriteByte(statement[i])
+			i++
+			continue
+		}
+		values, ok := qm.parameter(name)
+		if !ok {
+			out.WriteString(statement[i:end])
+			i = end
+			continue
+		}
+		if len(values) == 0 {
+			// a multi-value variable without a selected value matches nothing
+			values = []interface{}{nil}
+		}
+		in := followsIn(out.String())
+		if len(values) > 1 && !in {
+			return "", nil, fmt.Errorf("parameter $%s has %d values, a multi-value variable can only be used after IN", name, len(values))
+		}
+		bound := make([]string, len(values))
+		for j, value := range values {
+			if bound[j] = "?"; !prepared {
+				bound[j] = parameterLiteral(value)
+			}
+		}
+		if in {
+			out.WriteString("(" + strings.Join(bound, ", ") + ")")
+		} else {
+			out.WriteString(bound[0])
+		}
+		if prepared {
+			args = append(args, values...)
+		}
+		i = end
+	}
+	return out.String(), args, nil
+}
+
+// parameter returns the values of a parameter, the built in parameters are the time range in epoch milliseconds
+// and the interval of the query.
+func (qm queryModel) parameter(name string) ([]interface{}, bool) {
+	switch name {
+	case parameterFrom:
+		return []interface{}{qm.From.UnixNano() / 1e6}, !qm.From.IsZero()
+	case parameterTo:
+		return []interface{}{qm.To.UnixNano() / 1e6}, !qm.To.IsZero()
+	case parameterIntervalMs:
+		return []interface{}{int64(qm.IntervalMs)}, qm.IntervalMs > 0
+	}
+	values, ok := qm.Parameters[name]
+	if !ok {
+		return nil, false
+	}
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args, true
+}
+
+// parameterLiteral returns a value of a parameter as a sql literal.
+func parameterLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return quoteLiteral(fmt.Sprint(v))
+	}
+}
+
+// parameterReference returns the name of the $name, ${name} or ${name:format} reference at i and the index
+// after it, or an empty name when there is none. names in braces may have fields, like ${__user.login}. the
+// format of a reference does not apply to bound values and is dropped.
+func parameterReference(statement string, i int) (string, int) {
+	if strings.HasPrefix(statement[i:], "${") {
+		end := strings.IndexByte(statement[i:], '}')
+		if end < 0 {
+			return "", i
+		}
+		name := statement[i+2 : i+end]
+		if colon := strings.IndexByte(name, ':'); colon >= 0 {
+			name = name[:colon]
+		}
+		for _, part := range strings.Split(name, ".") {
+			if !isParameterName(part) {
+				return "", i
+			}
+		}
+		return name, i + end + 1
+	}
+	end := i + 1
+	for end < len(statement) && isParameterChar(statement[end], end == i+1) {
+		end++
+	}
+	return statement[i+1 : end], end
+}
+
+// isParameterName reports whether name is a parameter name, letters, digits and underscores not starting with a digit.
+func isParameterName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isParameterChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isParameterChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// followsIn reports whether the sql written so far ends with the keyword IN.
+func followsIn(sql string) bool {
+	sql = strings.TrimRight(sql, " \t\r\n")
+	if len(sql) < 2 || !strings.EqualFold(sql[len(sql)-2:], "IN") {
+		return false
+	}
+	return len(sql) == 2 || !isParameterChar(sql[len(sql)-3], false)
+}
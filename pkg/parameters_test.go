@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBindParameters(t *testing.T) {
+	qm := queryModel{
+		From:       time.Unix(1622505600, 0),
+		IntervalMs: 60000,
+		Parameters: map[string][]string{
+			"host":         {"a", "b'c"},
+			"node":         {"n1"},
+			"__user.login": {"admin"},
+		},
+	}
+	for _, test := range []struct {
+		statement string
+		prepared  bool
+		want      string
+		args      []interface{}
+	}{
+		{"SELECT * FROM t WHERE host IN $host AND node = $node", true, "SELECT * FROM t WHERE host IN (?, ?) AND node = ?", []interface{}{"a", "b'c", "n1"}},
+		{"SELECT * FROM t WHERE host IN $host AND node = $node", false, "SELECT * FROM t WHERE host IN ('a', 'b''c') AND node = 'n1'", []interface{}{}},
+		{"SELECT * FROM t WHERE node = ${node:sqlstring} AND t > $__from", true, "SELECT * FROM t WHERE node = ? AND t > ?", []interface{}{"n1", int64(1622505600000)}},
+		{"SELECT $__interval_ms, ${__user.login}", false, "SELECT 60000, 'admin'", []interface{}{}},
+		{"SELECT '$node', \"$node\", price || '$' FROM t -- $node", true, "SELECT '$node', \"$node\", price || '$' FROM t -- $node", []interface{}{}},
+		{"SELECT $unknown, ${unknown:csv}, $__timeFilter, $ FROM t WHERE node = $node", true, "SELECT $unknown, ${unknown:csv}, $__timeFilter, $ FROM t WHERE node = ?", []interface{}{"n1"}},
+	} {
+		got, args, err := qm.bindParameters(test.statement, test.prepared)
+		if err != nil {
+			t.Errorf("binding %q failed: %v", test.statement, err)
+			continue
+		}
+		if got != test.want || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("binding %q gave %q %v, want %q %v", test.statement, got, args, test.want, test.args)
+		}
+	}
+}
+
+func TestBindMultiValueOutsideIn(t *testing.T) {
+	qm := queryModel{Parameters: map[string][]string{"host": {"a", "b"}}}
+	for _, prepared := range []bool{true, false} {
+		if _, _, err := qm.bindParameters("SELECT * FROM t WHERE host = $host", prepared); err == nil || !strings.Contains(err.Error(), "after IN") {
+			t.Errorf("a multi-value variable outside IN returned %v, want an error", err)
+		}
+	}
+}
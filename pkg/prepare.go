@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// preparedQuery is the statement of a query model with the connection pool it runs on
+// and the session changes around it.
+type preparedQuery struct {
+	db        *sql.DB
+	statement string
+	// apply runs on the connection before the statement, reset before the connection goes back to the pool
+	apply []string
+	reset []string
+	// count is the statement counting the rows of all pages of a paginated query, see pagination.go
+	count string
+	// preceding are the statements before the last one of a query with several statements, see statements.go.
+	// they run on the connection of the query, which is discarded afterwards as they may change the session.
+	preceding []string
+	// args are the values of the bind parameters of statement, precedingArgs those of the preceding statements, see parameters.go
+	args          []interface{}
+	precedingArgs [][]interface{}
+	// audit writes the audit log entries of the preceding statements
+	audit func(statement string, err error, duration time.Duration, rows int)
+}
+
+// stepError names the step of preparing or running a query which failed, for the logs.
+type stepError struct {
+	step string
+	err  error
+}
+
+func (e *stepError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// failedStep returns the step named by a stepError in err, or fallback.
+func failedStep(err error, fallback string) string {
+	var se *stepError
+	if errors.As(err, &se) {
+		return se.step
+	}
+	return fallback
+}
+
+// prepareQuery resolves the connection pool, the statement and the session changes of a query model.
+// panel queries and the resource routes prepare their statements the same way, so they run with the
+// same identity, resource pool, workload and label.
+func (s *instanceSettings) prepareQuery(rc requestContext, qm queryModel, refID string) (preparedQuery, error) {
+	//the resource pool and workload of the query have to be allowed by the data source
+	if err := s.config.validateRouting(qm); err != nil {
+		return preparedQuery{}, &stepError{step: "validateRouting", err: err}
+	}
+	//with identity forwarding the query runs as the vertica user or with the roles mapped to the grafana user
+	identity, roles, err := s.config.UserIdentity.resolve(rc.PluginContext.User, s.secureJSONData)
+	if err == nil && s.config.forwardsUserToken() {
+		identity, err = forwardedIdentity(rc)
+	}
+	if err != nil {
+		return preparedQuery{}, &stepError{step: "resolveIdentity", err: err}
+	}
+	db, err := s.connectionPool(qm.Workload, identity)
+	if err != nil {
+		return preparedQuery{}, &stepError{step: "connectionPool", err: err}
+	}
+	//session changes for this query only, the reset statements run before the connection goes back to the pool
+	apply, reset := s.resourcePoolStatements(qm)
+	if len(roles) > 0 {
+		roleApply, roleReset := roleStatements(roles)
+		apply = append(apply, roleApply...)
+		reset = append(reset, roleReset...)
+	}
+	statement, err := qm.builderStatement()
+	if err != nil {
+		return preparedQuery{}, &stepError{step: "builder", err: err}
+	}
+	//the filters, pagination and label of a query with several statements apply to its last statement
+	var preceding []string
+	statements := splitStatements(statement)
+	if len(statements) > 1 {
+		preceding, statement = statements[:len(statements)-1], statements[len(statements)-1]
+	}
+	//with mapped roles the statements of the query must not change the roles or the user of the session
+	if len(roles) > 0 {
+		if err := checkSessionStatements(statements); err != nil {
+			return preparedQuery{}, &stepError{step: "resolveIdentity", err: err}
+		}
+	}
+	//with bind parameters the variables are sent to vertica as values, instead of being replaced in the sql.
+	//without prepared statements they are quoted as literals by the plugin
+	var args []interface{}
+	precedingArgs := make([][]interface{}, len(preceding))
+	if qm.BindParameters {
+		for i := range preceding {
+			if preceding[i], precedingArgs[i], err = qm.bindParameters(preceding[i], s.config.UsePreparedStatement); err != nil {
+				return preparedQuery{}, &stepError{step: "bindParameters", err: err}
+			}
+		}
+		if statement, args, err = qm.bindParameters(statement, s.config.UsePreparedStatement); err != nil {
+			return preparedQuery{}, &stepError{step: "bindParameters", err: err}
+		}
+	}
+	if len(qm.AdhocFilters) > 0 {
+		statement, err = applyAdhocFilters(statement, qm.AdhocFilters)
+		if err != nil {
+			return preparedQuery{}, &stepError{step: "adhocFilters", err: err}
+		}
+	}
+	//the log volume and the context of a log line wrap the log query, see logs.go
+	if qm.QueryType == queryTypeLogs && qm.Logs != nil && qm.Logs.Volume {
+		statement = qm.Logs.volumeStatement(statement, qm)
+	}
+	if qm.logsContext != nil {
+		statement = qm.logsContext.apply(statement)
+	}
+	var count string
+	if qm.Pagination != nil {
+		statement, count, err = qm.Pagination.apply(statement)
+		if err != nil {
+			return preparedQuery{}, &stepError{step: "pagination", err: err}
+		}
+	}
+	labelConfig := s.config.QueryLabel
+	if label := labelConfig.label(rc, qm, refID); label != "" {
+		if labelConfig.useHint() {
+			statement = addLabelHint(statement, label)
+		}
+		if labelConfig.useClientLabel() {
+			labelApply, labelReset := s.clientLabelStatements(label)
+			apply = append(apply, labelApply...)
+			reset = append(reset, labelReset...)
+		}
+	}
+	audit := func(statement string, err error, duration time.Duration, rows int) {
+		s.audit(rc, refID, statement, err, errorClassQuery, duration, rows)
+	}
+	return preparedQuery{db: db, statement: statement, apply: apply, reset: reset, count: count,
+		preceding: preceding, args: args, precedingArgs: precedingArgs, audit: audit}, nil
+}
+
+// the session characteristics of the queries of exports, cursors, explain and the context of log lines.
+const (
+	readOnlySession  = "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY"
+	readWriteSession = "SET SESSION CHARACTERISTICS AS TRANSACTION READ WRITE"
+)
+
+// readOnly makes the query run in read only transactions, vertica refuses the statements changing data in them, also
+// those calling functions like DROP_PARTITIONS from a SELECT. the routes running queries outside of a panel only read
+// the result of a single statement, readOnly fails for queries with several.
+func (p *preparedQuery) readOnly() error {
+	if len(p.preceding) > 0 {
+		return errors.New("only a single statement is allowed, the query has several")
+	}
+	p.apply = append(p.apply, readOnlySession)
+	p.reset = append(p.reset, readWriteSession)
+	return nil
+}
+
+// sql returns the statements of the query as sent to vertica.
+func (p preparedQuery) sql() string {
+	return strings.Join(append(append([]string{}, p.preceding...), p.statement), ";\n")
+}
+
+// run runs statement, the prepared statement or one built from it, on a pooled connection with the session changes applied.
+// a broken connection is replaced once by a connection to the next reachable host, unless the query has preceding statements.
+// the preceding statements run first on the same connection, their results are discarded.
+// the connection has to be released with releasePrepared.
+func (p preparedQuery) run(ctx context.Context, statement string, timing *queryTiming) (*sql.Conn, *sql.Rows, error) {
+	return p.runReading(ctx, statement, timing, nil)
+}
+
+// runReading is run, with read called with the index and the result of every preceding statement, it returns the rows read.
+func (p preparedQuery) runReading(ctx context.Context, statement string, timing *queryTiming, read func(int, *sql.Rows) (int, error)) (*sql.Conn, *sql.Rows, error) {
+	if len(p.apply) == 0 && len(p.preceding) == 0 {
+		return queryWithReconnect(ctx, p.db, statement, nil, true, timing, p.args...)
+	}
+	setup := func(connection *sql.Conn) error {
+		if err := runSessionStatements(ctx, connection, p.apply); err != nil {
+			return err
+		}
+		for i, preceding := range p.preceding {
+			start := time.Now()
+			rows, err := connection.QueryContext(ctx, preceding, p.precedingArgs[i]...)
+			count := 0
+			if err == nil {
+				if read != nil {
+					count, err = read(i, rows)
+				} else {
+					for rows.Next() {
+						count++
+					}
+					err = rows.Err()
+				}
+				rows.Close()
+			}
+			if p.audit != nil {
+				p.audit(preceding, err, time.Since(start), count)
+			}
+			if err != nil {
+				return &stepError{step: "precedingStatement", err: fmt.Errorf("statement %d failed: %w", i+1, err)}
+			}
+		}
+		return nil
+	}
+	//the preceding statements may change data, a query which ran them is not run again on a new connection
+	return queryWithReconnect(ctx, p.db, statement, setup, len(p.preceding) == 0, timing, p.args...)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// resourceHandler routes the resource calls of the data source, grafana serves them under
+// /api/datasources/<id>/resources/.
+func (td *VerticaDatasource) resourceHandler() backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/explain", td.handleExplain)
+	mux.HandleFunc("/variable", td.handleVariable)
+	mux.HandleFunc("/adhoc/keys", td.handleAdhocKeys)
+	mux.HandleFunc("/adhoc/values", td.handleAdhocValues)
+	mux.HandleFunc("/builder/sql", td.handleBuilderSQL)
+	mux.HandleFunc("/export", td.handleExport)
+	mux.HandleFunc("/logs/context", td.handleLogsContext)
+	mux.HandleFunc("/cursor", td.handleCursorOpen)
+	mux.HandleFunc("/cursor/next", td.handleCursorNext)
+	mux.HandleFunc("/cursor/close", td.handleCursorClose)
+	return httpadapter.New(mux)
+}
+
+// resourceRequest returns the request context and the data source instance of a resource call.
+func (td *VerticaDatasource) resourceRequest(r *http.Request) (requestContext, *instanceSettings, error) {
+	pluginContext := httpadapter.PluginConfigFromContext(r.Context())
+	headers := make(map[string]string, len(r.Header))
+	for key := range r.Header {
+		headers[key] = r.Header.Get(key)
+	}
+	rc := requestContext{
+		PluginContext: pluginContext,
+		Headers:       headers,
+	}
+	instance, err := td.getInstance(pluginContext)
+	return rc, instance, err
+}
+
+// resourceBody is the body of a resource call, a query model or a request holding query models.
+type resourceBody interface {
+	// defaults fills in the fields the frontend may leave out
+	defaults()
+}
+
+// defaults uses the query string as the templated query when the frontend did not send one.
+func (qm *queryModel) defaults() {
+	if qm.QueryTemplated == "" {
+		qm.QueryTemplated = qm.QueryString
+	}
+}
+
+// decodeRequest reads the POST body of a resource call into body, writing the error response when it fails.
+// the resource routes accept the query model of panel queries, with the variables already replaced in
+// queryTemplated by the frontend.
+func decodeRequest(w http.ResponseWriter, r *http.Request, body resourceBody) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed, use POST", r.Method))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return false
+	}
+	body.defaults()
+	return true
+}
+
+// writeJSON writes v as the json response of a resource call.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.DefaultLogger.Warn("writing resource response failed", "error", err.Error())
+	}
+}
+
+// writeError writes err as the json response of a failed resource call.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	_ "github.com/vertica/vertica-sql-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // newDatasource returns datasource.ServeOpts.
@@ -28,8 +30,9 @@ func newDatasource() datasource.ServeOpts {
 	}
 
 	return datasource.ServeOpts{
-		QueryDataHandler:   ds,
-		CheckHealthHandler: ds,
+		QueryDataHandler:    ds,
+		CheckHealthHandler:  ds,
+		CallResourceHandler: ds.resourceHandler(),
 	}
 }
 
@@ -42,21 +45,21 @@ type VerticaDatasource struct {
 	im instancemgmt.InstanceManager
 }
 
-//GetVerticaDb will return the vertica db connection
-//stored in the instance setting when the instance is created or update
-func (td *VerticaDatasource) GetVerticaDb(pluginContext backend.PluginContext) (*sql.DB, error) {
+// getInstance returns the instance settings of the data source in the plugin context.
+func (td *VerticaDatasource) getInstance(pluginContext backend.PluginContext) (*instanceSettings, error) {
 	instance, err := td.im.Get(pluginContext)
 	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("getVerticaDb: %s", err))
+		log.DefaultLogger.Error("data source instance unavailable", "orgId", pluginContext.OrgID, "error", err.Error())
 		return nil, err
 	}
-	if instanceSetting, ok := instance.(*instanceSettings); ok {
-		return instanceSetting.Db, nil
-	} else {
-		log.DefaultLogger.Info(fmt.Sprintf("getVerticaDb: %s", err))
+	instanceSetting, ok := instance.(*instanceSettings)
+	if !ok {
+		err = fmt.Errorf("unexpected instance type %T", instance)
+		log.DefaultLogger.Error("data source instance unavailable", "orgId", pluginContext.OrgID, "error", err.Error())
 		return nil, err
 	}
-
+	instanceSetting.connector.setOrgID(pluginContext.OrgID)
+	return instanceSetting, nil
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -70,20 +73,28 @@ func (td *VerticaDatasource) QueryData(ctx context.Context, req *backend.QueryDa
 		wg       = sync.WaitGroup{}
 	)
 
-	wg.Add(len(req.Queries))
+	rc := requestContext{
+		PluginContext: req.PluginContext,
+		Headers:       req.Headers,
+	}
+	ctx, span := tracer.Start(traceContext(ctx, rc), "QueryData", trace.WithAttributes(
+		attribute.Int("queries", len(req.Queries)),
+	))
+	defer span.End()
 
-	db, err := td.GetVerticaDb(req.PluginContext)
+	instance, err := td.getInstance(req.PluginContext)
 	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("queryData :getVerticaDB: %s", err))
+		endSpan(span, err)
 		return nil, err
 	}
+	span.SetAttributes(attribute.String("datasource.uid", instance.UID))
 
 	// loop over queries and execute them individually.
 	// added waitGroup to concurrently execute queries
-
+	wg.Add(len(req.Queries))
 	for _, q := range req.Queries {
 		go func(query backend.DataQuery) {
-			res := td.query(ctx, query, db)
+			res := td.query(ctx, rc, query, instance)
 			response.Set(query.RefID, res)
 			wg.Done()
 		}(q)
@@ -104,11 +115,33 @@ type queryModel struct {
 	TimeFillValue   float64 `json:"timeFillStaticValue,omitempty"`
 	QueryType       string  `json:"format,omitempty"`
 	IntervalMs      int     `json:"intervalMs,omitempty"`
+	ResourcePool    string  `json:"resourcePool,omitempty"`
+	Workload        string  `json:"workload,omitempty"`
+	DashboardUID    string  `json:"dashboardUID,omitempty"`
+	PanelID         int64   `json:"panelId,omitempty"`
 	From            time.Time
 	To              time.Time
+	// AdhocFilters are the filters of the ad-hoc filter variables of the dashboard, see adhoc.go
+	AdhocFilters []adhocFilter `json:"adhocFilters,omitempty"`
+	// EditorMode is code or builder, builder queries are rendered from Builder, see builder.go
+	EditorMode string        `json:"editorMode,omitempty"`
+	Builder    *builderQuery `json:"builder,omitempty"`
+	// Pagination returns a page of the result, see pagination.go
+	Pagination *queryPagination `json:"pagination,omitempty"`
+	// ResultSets is all or last, the result sets of a query with several statements returned as frames, see statements.go
+	ResultSets string `json:"resultSets,omitempty"`
+	// BindParameters sends $name references as bind parameters with the values of Parameters, see parameters.go
+	BindParameters bool                `json:"bindParameters,omitempty"`
+	Parameters     map[string][]string `json:"parameters,omitempty"`
+	// Annotation maps the columns of an annotation query to the annotation fields, see annotation.go
+	Annotation *annotationColumns `json:"annotation,omitempty"`
+	// Logs maps the columns of a log query to the log lines, see logs.go
+	Logs *logsOptions `json:"logs,omitempty"`
+	// logsContext selects the context of a log line, set by the /logs/context route
+	logsContext *logsContext
 }
 
-func (td *VerticaDatasource) query(ctx context.Context, query backend.DataQuery, db *sql.DB) backend.DataResponse {
+func (td *VerticaDatasource) query(ctx context.Context, rc requestContext, query backend.DataQuery, instance *instanceSettings) backend.DataResponse {
 	// Unmarshal the json into queryModel type
 	var qm queryModel
 
@@ -119,7 +152,7 @@ func (td *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 	qm.From = query.TimeRange.From
 
 	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("queryData :getVerticaDB: %s", err))
+		log.DefaultLogger.Warn("invalid query model", append(instance.logFields(rc, query.RefID), "error", err.Error())...)
 		response.Error = err
 		return response
 	}
@@ -128,87 +161,139 @@ func (td *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 	if qm.Hide {
 		return response
 	}
-
-	connection, err := db.Conn(ctx)
-	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("queryData :connection: %s", err))
+	observation := observeQuery(instance.UID, qm.QueryType)
+	ctx, span := tracer.Start(ctx, "query", trace.WithAttributes(
+		attribute.String("refId", query.RefID),
+		attribute.String("format", qm.QueryType),
+	))
+	defer func() {
+		observation.done(response.Error)
+		instance.logQuery(rc, query.RefID, observation, response.Error)
+		span.SetAttributes(attribute.Int("rows", observation.rows))
+		endSpan(span, response.Error)
+	}()
+	// fail records the failed step, the deferred functions log it
+	fail := func(step string, err error) backend.DataResponse {
+		observation.step = step
 		response.Error = err
 		return response
 	}
 
-	defer connection.Close()
-	//run query
-	rows, err := connection.QueryContext(ctx, qm.QueryTemplated)
+	prepared, err := instance.prepareQuery(rc, qm, query.RefID)
 	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("queryData :queryContext: %s", err))
-		response.Error = err
-		return response
+		return fail(failedStep(err, "prepareQuery"), err)
 	}
-	defer rows.Close()
 
-	//get the column names, columns will be use to added a header names to the data frame
-	columns, err := rows.Columns()
-	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("queryData :columns: %s", err))
-		response.Error = err
-		return response
+	//run query, a broken connection is replaced once by a connection to the next reachable host
+	//the statements before the last one of a query with several statements run first, each result set is a frame
+	observation.stage = errorClassQuery
+	observation.statement = prepared.statement
+	observation.args = prepared.args
+	results := make([]*data.Frame, len(prepared.preceding))
+	read := func(i int, rows *sql.Rows) (int, error) {
+		if qm.ResultSets == resultSetsLast {
+			count := 0
+			for rows.Next() {
+				count++
+			}
+			return count, rows.Err()
+		}
+		before := observation.rows
+		frame, _, err := scanFrame(rows, fmt.Sprintf("%s-%d", query.RefID, i+1), observation)
+		results[i] = frame
+		return observation.rows - before, err
 	}
-
-	//get the column types, column type will be used to convert column from sql type to data frame type. (implemented in types.go generateFrameType)
-	columnTypes, err := rows.ColumnTypes()
+	connection, rows, err := prepared.runReading(ctx, prepared.statement, &observation.timing, read)
 	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("queryData :columnTypes: %s", err))
-		response.Error = err
-		return response
+		return fail(failedStep(err, "queryContext"), err)
 	}
-
-	//most of the SQL data source will return mostly a long frame, if group by is used.
-	//so by default a long frame will be created.
-	//generate the column types, using the info from columnTypes.
-	// use the name (refId in query json) of the query as frame name
-	longFrame := data.NewFrameOfFieldTypes(query.RefID, 0, generateFrameType(columnTypes)...)
-	//setting the header names to the frame , the names are same as return by the driver.
-	longFrame.SetFieldNames(columns...)
+	defer instance.releasePrepared(connection, prepared)
+	defer rows.Close()
 
 	//scaning fro rows.
-	for rows.Next() {
-		//generateRowIn returns an []interface{}, but based on the columns type variable to the retrived type is added.
-		rowIn := generateRowIn(columnTypes)
-		err = rows.Scan(rowIn...)
+	//the frame is built column by column, the rows are scanned into the reused buffers of typed column builders (see frame.go)
+	fetchStart := time.Now()
+	_, scanSpan := tracer.Start(ctx, "scan")
+	// use the name (refId in query json) of the query as frame name, the field names are the same as returned by the driver.
+	longFrame, step, err := scanFrame(rows, query.RefID, observation)
+	observation.timing.Fetch = time.Since(fetchStart)
+	scanSpan.SetAttributes(attribute.Int("rows", observation.rows))
+	endSpan(scanSpan, err)
+	if err != nil {
+		return fail(step, err)
+	}
+	//the rows are read, the connection is free to look up the vertica ids of the query. they are looked up before
+	//the count of the pagination runs, the ids of the last statement would be those of the count
+	rows.Close()
+	if instance.config.SlowQuery.profiles() {
+		observation.statementIDs(ctx, connection)
+	}
+	tagStatementIDs(ctx, span, connection, observation)
+	var page *pageInfo
+	if qm.Pagination != nil && longFrame != nil {
+		info, err := qm.Pagination.pageInfo(ctx, connection, prepared.count, prepared.args, longFrame)
 		if err != nil {
-			log.DefaultLogger.Info(fmt.Sprintf("queryData :row.Scan: %s", err))
-			response.Error = err
-			return response
+			log.DefaultLogger.Warn("counting the rows of a paginated query failed", append(instance.logFields(rc, query.RefID), "error", err.Error())...)
 		}
-
-		//append the scanned rows to the frame.
-		longFrame.AppendRow(rowIn...)
-
+		page = &info
+	}
+	observation.stage = errorClassConversion
+	frames := make([]*data.Frame, 0, len(results)+1)
+	for _, frame := range results {
+		// statements like SET return no result set
+		if frame != nil {
+			frames = append(frames, frame)
+		}
+	}
+	if longFrame == nil {
+		longFrame = data.NewFrame(query.RefID)
 	}
+	frames = append(frames, longFrame)
+	for _, frame := range frames {
+		formatted, err := instance.formatFrame(ctx, rc, qm, query.RefID, frame)
+		if err != nil {
+			return fail(failedStep(err, "format"), err)
+		}
+		response.Frames = append(response.Frames, formatted...)
+	}
+	if page != nil {
+		for _, frame := range response.Frames {
+			setFrameCustom(frame, "pagination", page)
+		}
+	}
+	//queries slower than the threshold are logged and get a notice, with the plan when configured
+	instance.reportSlowQuery(ctx, rc, query.RefID, connection, observation, response.Frames)
+	return response
+}
+
+// formatFrame formats a long frame read from vertica as the query type of the query asks for, log queries
+// return a frame for every combination of label values.
+func (s *instanceSettings) formatFrame(ctx context.Context, rc requestContext, qm queryModel, refID string, longFrame *data.Frame) ([]*data.Frame, error) {
+	var err error
 	//will use the queryType parameter from query to format the time series
 	switch qm.QueryType {
 	case "Time Series":
 		// is 0 rows received , return an empty long frame
 		if longFrame.Rows() == 0 {
-			response.Frames = append(response.Frames, data.NewFrame(query.RefID))
+			return []*data.Frame{data.NewFrame(longFrame.Name)}, nil
 		} else {
 			//check of for frame type if not wide convert it to wide , when the query Type is time series.
 			if longFrame.TimeSeriesSchema().Type != data.TimeSeriesTypeWide {
+				_, convertSpan := tracer.Start(ctx, "LongToWide")
 				longFrame, err = data.LongToWide(longFrame, nil)
+				endSpan(convertSpan, err)
 				if err != nil {
-					log.DefaultLogger.Info(fmt.Sprintf("queryData :LongToWide: %s", err))
-					response.Error = err
-					return response
+					return nil, &stepError{step: "LongToWide", err: err}
 				}
 			}
 
 			//fill time gaps if TimeFillEnabled is true
 			if qm.TimeFillEnabled {
+				_, fillSpan := tracer.Start(ctx, "TimeGapFill")
 				frame, err := TimeGapFill(longFrame, qm)
+				endSpan(fillSpan, err)
 				if err != nil {
-					log.DefaultLogger.Info(fmt.Sprintf("queryData :TimeGapFill: %s", err))
-					response.Error = err
-					return response
+					return nil, &stepError{step: "TimeGapFill", err: err}
 				}
 				fillMissing := &data.FillMissing{}
 				switch qm.TimeFillMode {
@@ -235,7 +320,7 @@ func (td *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 							if _, ok := field.ConcreteAt(rowIdx); !ok {
 								filled, err := data.GetMissing(fillMissing, field, previousRow)
 								if err != nil {
-									log.DefaultLogger.Info(fmt.Sprintf("queryData :fillmissing : %s", err))
+									log.DefaultLogger.Warn("fill missing value failed", append(s.logFields(rc, refID), "field", field.Name, "row", rowIdx, "error", err.Error())...)
 								} else {
 									field.Set(rowIdx, filled)
 								}
@@ -246,22 +331,42 @@ func (td *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 					}
 
 				}
-				response.Frames = append(response.Frames, frame)
+				return []*data.Frame{frame}, nil
 			} else {
-				response.Frames = append(response.Frames, longFrame)
+				return []*data.Frame{longFrame}, nil
 			}
 
 		}
+	case queryTypeAnnotations:
+		//annotations are converted even without rows, a result missing the columns fails the query
+		frame, err := annotationFrame(longFrame, qm.Annotation)
+		if err != nil {
+			return nil, &stepError{step: "annotations", err: err}
+		}
+		return []*data.Frame{frame}, nil
+	case queryTypeLogs:
+		//the log volume is a time series of the log lines per level
+		if qm.Logs != nil && qm.Logs.Volume {
+			frame, err := logsVolumeFrame(longFrame)
+			if err != nil {
+				return nil, &stepError{step: "logsVolume", err: err}
+			}
+			return []*data.Frame{frame}, nil
+		}
+		frames, err := logsFrames(longFrame, qm.Logs)
+		if err != nil {
+			return nil, &stepError{step: "logs", err: err}
+		}
+		return frames, nil
 	default:
 		//response for rest of the query types a long frame
 		if longFrame.Rows() == 0 {
-			response.Frames = append(response.Frames, data.NewFrame(query.RefID))
+			return []*data.Frame{data.NewFrame(longFrame.Name)}, nil
 		} else {
-			response.Frames = append(response.Frames, longFrame)
+			return []*data.Frame{longFrame}, nil
 		}
 
 	}
-	return response
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
@@ -271,50 +376,81 @@ func (td *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 func (td *VerticaDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	var status = backend.HealthStatusOk
 
-	db, err := td.GetVerticaDb(req.PluginContext)
+	instance, err := td.getInstance(req.PluginContext)
 	if err != nil {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
 			Message: fmt.Sprintf("%s", err),
 		}, nil
 	}
+	if instance.config.forwardsUserToken() {
+		// health checks carry no user token to log in with
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusUnknown,
+			Message: "Connections log in with the oauth token of the grafana user, the connection is tested when a user queries the data source",
+		}, nil
+	}
+	db := instance.Db
 	// https://golang.org/pkg/database/sql/#DBStats
-	log.DefaultLogger.Info(fmt.Sprintf("stats before query: %v", db.Stats()))
+	log.DefaultLogger.Debug("health check pool stats before query", "datasource", instance.Name, "stats", db.Stats())
 	connection, err := db.Conn(ctx)
 	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("CheckHealth :connection: %s", err))
+		log.DefaultLogger.Warn("health check failed", "datasource", instance.Name, "step", "connection", "error", err.Error())
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
 			Message: fmt.Sprintf("%s", err),
 		}, nil
 	}
 	defer connection.Close()
-	result, err := connection.QueryContext(ctx, "SELECT version()")
+	result, err := connection.QueryContext(ctx, "SELECT version(), local_node_name()")
 	if err != nil {
-		log.DefaultLogger.Info(fmt.Sprintf("CheckHealth :QueryContext: %s", err))
+		log.DefaultLogger.Warn("health check failed", "datasource", instance.Name, "step", "queryContext", "error", err.Error())
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
 			Message: fmt.Sprintf("%s", err),
 		}, nil
 	}
 	defer result.Close()
-	var queryResult string
+	var queryResult, nodeName string
 
 	if result.Next() {
-		err = result.Scan(&queryResult)
+		err = result.Scan(&queryResult, &nodeName)
 		if err != nil {
-			log.DefaultLogger.Info(fmt.Sprintf("CheckHealth :Scan: %s", err))
+			log.DefaultLogger.Warn("health check failed", "datasource", instance.Name, "step", "scan", "error", err.Error())
 			return &backend.CheckHealthResult{
 				Status:  backend.HealthStatusError,
 				Message: fmt.Sprintf("%s", err),
 			}, nil
 		}
 	}
+	// the result has to be closed before running the next statement on the same connection
+	result.Close()
 	// https://golang.org/pkg/database/sql/#DBStats
-	log.DefaultLogger.Info(fmt.Sprintf("CheckHealth: stats after query: %v", db.Stats()))
+	log.DefaultLogger.Debug("health check pool stats after query", "datasource", instance.Name, "stats", db.Stats())
+	session, err := verifySessionParameters(ctx, connection, instance.config.SessionParameters, instance.connector.sessionTemplate())
+	if err != nil {
+		log.DefaultLogger.Warn("health check failed", "datasource", instance.Name, "step", "verifySessionParameters", "error", err.Error())
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: fmt.Sprintf("%s", err),
+		}, nil
+	}
+	message := fmt.Sprintf("Successfully connected to %s", queryResult)
+	if session != "" {
+		message = fmt.Sprintf("%s, session %s", message, session)
+	}
+	serving, hosts := instance.connector.Status()
+	if serving != "" {
+		message = fmt.Sprintf("%s, node %s served by host %s", message, nodeName, serving)
+	}
+	for _, host := range hosts {
+		if !host.Healthy {
+			message = fmt.Sprintf("%s, host %s is unreachable: %s", message, host.Host, host.LastError)
+		}
+	}
 	return &backend.CheckHealthResult{
 		Status:  status,
-		Message: fmt.Sprintf("Successfully connected to %s", queryResult),
+		Message: message,
 	}, nil
 }
 
@@ -322,34 +458,108 @@ type instanceSettings struct {
 	httpClient *http.Client
 	Db         *sql.DB
 	Name       string
+	UID        string
+	connector  *failoverConnector
+	config     datasourceConfig
+	// secureJSONData holds the credentials of the vertica users grafana users are mapped to
+	secureJSONData map[string]string
+
+	// pools are the connection pools of other workloads and of forwarded user identities, created on first use.
+	mtx   sync.Mutex
+	pools map[string]*connectionPool
+
+	// cursors are the open cursors of the /cursor routes, see cursor.go
+	cursorMtx sync.Mutex
+	cursors   map[string]*resultCursor
+	// cursorSlots counts the open cursors and the cursors being opened, limited by cursor.maxOpen
+	cursorSlots int
 }
 
 // newDataSourceInstance is called always when a datasource is created or updated in the ui
 // in either create or update, open a pool of sql connections and update them
 func newDataSourceInstance(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
 	var config datasourceConfig
-	secret := setting.DecryptedSecureJSONData["password"]
 	err := json.Unmarshal(setting.JSONData, &config)
 	if err != nil {
-		log.DefaultLogger.Error(fmt.Sprintf("newDataSourceInstance :Unmarshal: %s", err))
+		log.DefaultLogger.Error("invalid data source configuration", "datasource", setting.Name, "error", err.Error())
+		return nil, fmt.Errorf("invalid data source configuration: %w", err)
 	}
-	connStr := config.ConnectionURL(secret)
-	db, err := sql.Open("vertica", connStr)
+	for _, warning := range config.applyPoolDefaults() {
+		log.DefaultLogger.Warn("pool setting replaced by default", "datasource", setting.Name, "warning", warning)
+	}
+	if err := config.validateAuth(); err != nil {
+		log.DefaultLogger.Error("invalid authentication settings", "datasource", setting.Name, "error", err.Error())
+		return nil, err
+	}
+	httpClient := &http.Client{}
+	credentials, err := config.credentialProvider(setting.DecryptedSecureJSONData, httpClient)
 	if err != nil {
+		log.DefaultLogger.Error("invalid credential settings", "datasource", setting.Name, "error", err.Error())
 		return nil, err
 	}
-	db.SetMaxOpenConns(config.MaxOpenConnections)
-	db.SetMaxIdleConns(config.MaxIdealConnections)
-	db.SetConnMaxIdleTime(time.Minute * time.Duration(config.MaxConnectionIdealTime))
-	log.DefaultLogger.Info(fmt.Sprintf("newDataSourceInstance: new instance fo datasource created: %s", setting.Name))
+	connector, err := newFailoverConnector(config, credentials, sessionTemplate{
+		DatasourceID:   setting.ID,
+		DatasourceUID:  setting.UID,
+		DatasourceName: setting.Name,
+	})
+	if err != nil {
+		log.DefaultLogger.Error("invalid connection settings", "datasource", setting.Name, "error", err.Error())
+		return nil, fmt.Errorf("invalid connection settings: %w", err)
+	}
+	db := sql.OpenDB(connector)
+	configurePool(db, config)
+	switch provider := credentials.(type) {
+	case *clientCredentialsToken:
+		provider.datasourceUID = setting.UID
+		// connections are recycled before the token they were opened with expires
+		provider.onRefresh = func(lifetime time.Duration) {
+			if tokenLifetime := connectionLifetime(lifetime); tokenLifetime < config.connMaxLifetime() {
+				db.SetConnMaxLifetime(tokenLifetime)
+			}
+		}
+	case *rotatingPassword:
+		// idle connections are closed, so the pool moves to the new password. connections in use finish their query
+		provider.onChange = func() {
+			closeIdleConnections(db, config)
+		}
+	}
+	poolStats.add(db, poolLabels{Datasource: setting.Name, DatasourceUID: setting.UID, Pool: "default"})
+	log.DefaultLogger.Info("data source instance created", "datasource", setting.Name, "datasourceUid", setting.UID)
 	return &instanceSettings{
-		httpClient: &http.Client{},
+		httpClient: httpClient,
 		Db:         db,
 		Name:       setting.Name,
+		UID:        setting.UID,
+		connector:  connector,
+		config:     config,
+		// kept for the passwords of mapped vertica users
+		secureJSONData: setting.DecryptedSecureJSONData,
 	}, nil
 }
 
+// configurePool applies the pool settings of the data source to a connection pool.
+func configurePool(db *sql.DB, config datasourceConfig) {
+	db.SetMaxOpenConns(config.MaxOpenConnections)
+	db.SetMaxIdleConns(config.MaxIdealConnections)
+	db.SetConnMaxIdleTime(time.Minute * time.Duration(config.MaxConnectionIdealTime))
+	db.SetConnMaxLifetime(config.connMaxLifetime())
+}
+
+// closeIdleConnections closes the idle connections of a pool, connections in use are not affected.
+func closeIdleConnections(db *sql.DB, config datasourceConfig) {
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(config.MaxIdealConnections)
+}
+
 func (s *instanceSettings) Dispose() {
+	s.closeCursors()
+	poolStats.remove(s.Db)
 	s.Db.Close()
-	log.DefaultLogger.Info(fmt.Sprintf("db connections of datasource %s closed", s.Name))
+	s.mtx.Lock()
+	for _, pool := range s.pools {
+		poolStats.remove(pool.db)
+		pool.db.Close()
+	}
+	s.mtx.Unlock()
+	log.DefaultLogger.Info("data source instance disposed, connections closed", "datasource", s.Name, "datasourceUid", s.UID)
 }
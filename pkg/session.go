@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// sessionParameters are applied to every new physical connection, before any query runs on it.
+type sessionParameters struct {
+	ResourcePool string `json:"resourcePool,omitempty"`
+	SearchPath   string `json:"searchPath,omitempty"`
+	TimeZone     string `json:"timeZone,omitempty"`
+	ClientLabel  string `json:"clientLabel,omitempty"`
+}
+
+// sessionTemplate holds the values available to templated session parameters and init statements.
+type sessionTemplate struct {
+	OrgID          int64
+	DatasourceID   int64
+	DatasourceUID  string
+	DatasourceName string
+}
+
+// replace substitutes the ${__org.id}, ${__datasource.id}, ${__datasource.uid} and ${__datasource.name} variables.
+func (t sessionTemplate) replace(s string) string {
+	return strings.NewReplacer(
+		"${__org.id}", strconv.FormatInt(t.OrgID, 10),
+		"${__datasource.id}", strconv.FormatInt(t.DatasourceID, 10),
+		"${__datasource.uid}", t.DatasourceUID,
+		"${__datasource.name}", t.DatasourceName,
+	).Replace(s)
+}
+
+// sessionStatements returns the statements executed on each new connection,
+// the session parameters first followed by the configured init statements.
+func (config *datasourceConfig) sessionStatements(tmpl sessionTemplate) []string {
+	statements := make([]string, 0)
+	params := config.SessionParameters
+	if params.ResourcePool != "" {
+		statements = append(statements, "SET SESSION RESOURCE_POOL = "+quoteIdentifier(tmpl.replace(params.ResourcePool)))
+	}
+	if params.SearchPath != "" {
+		schemas := make([]string, 0)
+		for _, schema := range strings.Split(tmpl.replace(params.SearchPath), ",") {
+			if schema = strings.TrimSpace(schema); schema != "" {
+				schemas = append(schemas, quoteIdentifier(schema))
+			}
+		}
+		statements = append(statements, "SET SEARCH_PATH TO "+strings.Join(schemas, ", "))
+	}
+	if params.TimeZone != "" {
+		statements = append(statements, "SET TIME ZONE TO "+quoteLiteral(tmpl.replace(params.TimeZone)))
+	}
+	if params.ClientLabel != "" {
+		statements = append(statements, "SELECT SET_CLIENT_LABEL("+quoteLiteral(tmpl.replace(params.ClientLabel))+")")
+	}
+	for _, statement := range config.InitStatements {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, tmpl.replace(statement))
+		}
+	}
+	return statements
+}
+
+// quoteIdentifier quotes a vertica identifier, doubling any embedded double quote.
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a vertica string literal, doubling any embedded single quote.
+func quoteLiteral(literal string) string {
+	return "'" + strings.ReplaceAll(literal, "'", "''") + "'"
+}
+
+// initializeConnection runs the session statements on a freshly opened driver connection.
+// the statements are run through prepare and query since the vertica driver does not implement driver.ExecerContext.
+func initializeConnection(ctx context.Context, conn driver.Conn, statements []string) error {
+	for _, statement := range statements {
+		if err := execDriverStatement(ctx, conn, statement); err != nil {
+			return fmt.Errorf("session initialization %q failed: %w", statement, err)
+		}
+	}
+	return nil
+}
+
+func execDriverStatement(ctx context.Context, conn driver.Conn, statement string) error {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if preparer, ok := conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, statement)
+	} else {
+		stmt, err = conn.Prepare(statement)
+	}
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var rows driver.Rows
+	if queryer, ok := stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, nil)
+	} else {
+		rows, err = stmt.Query(nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	// drain the result, statements like SET_CLIENT_LABEL return a row
+	values := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err := rows.Next(values); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// verifySessionParameters reads back the configured session parameters on a pooled connection
+// and returns a description of the session, or an error naming the parameter which does not match.
+func verifySessionParameters(ctx context.Context, connection *sql.Conn, params sessionParameters, tmpl sessionTemplate) (string, error) {
+	checks := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"resource pool", "SHOW RESOURCE POOL", params.ResourcePool},
+		{"time zone", "SHOW TIMEZONE", params.TimeZone},
+		{"search path", "SHOW SEARCH_PATH", params.SearchPath},
+		{"client label", "SELECT 'client_label', GET_CLIENT_LABEL()", params.ClientLabel},
+	}
+	settings := make([]string, 0)
+	for _, check := range checks {
+		if check.expected == "" {
+			continue
+		}
+		var name, value string
+		if err := connection.QueryRowContext(ctx, check.query).Scan(&name, &value); err != nil {
+			return "", fmt.Errorf("failed to read session %s: %w", check.name, err)
+		}
+		expected := tmpl.replace(check.expected)
+		matches := strings.EqualFold(value, expected)
+		if check.name == "search path" {
+			// vertica appends the implicit schemas to the search path
+			normalize := strings.NewReplacer(" ", "", `"`, "")
+			matches = strings.HasPrefix(strings.ToLower(normalize.Replace(value)), strings.ToLower(normalize.Replace(expected)))
+		}
+		if !matches {
+			return "", fmt.Errorf("session %s is %q, expected %q", check.name, value, expected)
+		}
+		settings = append(settings, fmt.Sprintf("%s %s", check.name, value))
+	}
+	return strings.Join(settings, ", "), nil
+}
+
+// runSessionStatements runs statements changing the session of a borrowed connection.
+// the statements are run with QueryContext since some of them, like SET_CLIENT_LABEL, return a row.
+func runSessionStatements(ctx context.Context, connection *sql.Conn, statements []string) error {
+	for _, statement := range statements {
+		rows, err := connection.QueryContext(ctx, statement)
+		if err != nil {
+			return fmt.Errorf("%q failed: %w", statement, err)
+		}
+		rows.Close()
+	}
+	return nil
+}
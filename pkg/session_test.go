@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSessionStatements(t *testing.T) {
+	tmpl := sessionTemplate{OrgID: 2, DatasourceID: 7, DatasourceUID: "abc", DatasourceName: `o'neil "dw"`}
+	for _, test := range []struct {
+		name   string
+		config datasourceConfig
+		want   []string
+	}{
+		{"nothing", datasourceConfig{}, []string{}},
+		{
+			name: "quoted parameters",
+			config: datasourceConfig{SessionParameters: sessionParameters{
+				ResourcePool: `pool"x`,
+				SearchPath:   ` public , "s" ,, org_${__org.id} `,
+				TimeZone:     "Europe/Berlin'; DROP TABLE t; --",
+				ClientLabel:  "grafana ${__datasource.name}",
+			}},
+			want: []string{
+				`SET SESSION RESOURCE_POOL = "pool""x"`,
+				`SET SEARCH_PATH TO "public", """s""", "org_2"`,
+				`SET TIME ZONE TO 'Europe/Berlin''; DROP TABLE t; --'`,
+				`SELECT SET_CLIENT_LABEL('grafana o''neil "dw"')`,
+			},
+		},
+		{
+			name:   "templated resource pool",
+			config: datasourceConfig{SessionParameters: sessionParameters{ResourcePool: "org_${__org.id}_${__datasource.uid}"}},
+			want:   []string{`SET SESSION RESOURCE_POOL = "org_2_abc"`},
+		},
+		{
+			name: "init statements after the parameters",
+			config: datasourceConfig{
+				SessionParameters: sessionParameters{TimeZone: "UTC"},
+				InitStatements:    []string{" SET DATESTYLE TO ISO ", "", "SELECT ${__datasource.id}"},
+			},
+			want: []string{"SET TIME ZONE TO 'UTC'", "SET DATESTYLE TO ISO", "SELECT 7"},
+		},
+	} {
+		if got := test.config.sessionStatements(tmpl); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, test := range []struct{ value, identifier, literal string }{
+		{"plain", `"plain"`, `'plain'`},
+		{`a"b`, `"a""b"`, `'a"b'`},
+		{"it's", `"it's"`, `'it''s'`},
+		{`'"'`, `"'""'"`, `'''"'''`},
+		{"", `""`, `''`},
+	} {
+		if got := quoteIdentifier(test.value); got != test.identifier {
+			t.Errorf("quoteIdentifier(%q) = %s, want %s", test.value, got, test.identifier)
+		}
+		if got := quoteLiteral(test.value); got != test.literal {
+			t.Errorf("quoteLiteral(%q) = %s, want %s", test.value, got, test.literal)
+		}
+	}
+}
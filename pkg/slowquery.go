@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// slowQueryConfig reports queries running longer than the threshold, see reportSlowQuery.
+type slowQueryConfig struct {
+	// ThresholdMs is the duration in milliseconds from which a query is slow, zero disables the reporting
+	ThresholdMs int `json:"thresholdMs,omitempty"`
+	// Plan is the plan fetched for slow queries: explain, profile or empty for none
+	Plan string `json:"plan,omitempty"`
+}
+
+// plans fetched for slow queries.
+const (
+	slowQueryPlanExplain = "explain"
+	slowQueryPlanProfile = "profile"
+)
+
+// maxPlanLines limits the plan attached to a frame, plans of large queries run into thousands of lines.
+const maxPlanLines = 500
+
+// profileQuery reads the execution profile of a statement, the path lines with the time and memory they used.
+const profileQuery = `SELECT path_line, COALESCE(running_time::VARCHAR, ''), COALESCE(memory_allocated_bytes, 0)
+FROM v_monitor.query_plan_profiles WHERE transaction_id = ? AND statement_id = ?
+ORDER BY path_id, path_line_index`
+
+// slowQuery is attached to the frames of a slow query, shown in the panel inspector.
+type slowQuery struct {
+	Duration string   `json:"duration"`
+	Connect  string   `json:"connect"`
+	Execute  string   `json:"execute"`
+	Fetch    string   `json:"fetch"`
+	Plan     []string `json:"plan,omitempty"`
+	PlanErr  string   `json:"planError,omitempty"`
+}
+
+func (config slowQueryConfig) threshold() time.Duration {
+	return time.Duration(config.ThresholdMs) * time.Millisecond
+}
+
+// profiles tells whether slow queries are reported with their profile, which needs the vertica ids of every query
+// as it is not known yet whether it is slow.
+func (config slowQueryConfig) profiles() bool {
+	return config.ThresholdMs > 0 && config.Plan == slowQueryPlanProfile
+}
+
+// reportSlowQuery logs a query which took longer than the slow query threshold with the time spent in
+// each phase and adds a warning notice to its frames. the plan of the query is fetched on the connection
+// the query ran on, so it sees the same session settings, and added to the frames and the log.
+func (s *instanceSettings) reportSlowQuery(ctx context.Context, rc requestContext, refID string, connection *sql.Conn, o *queryObservation, frames data.Frames) {
+	config := s.config.SlowQuery
+	duration := o.duration()
+	if config.ThresholdMs <= 0 || duration < config.threshold() {
+		return
+	}
+	report := slowQuery{
+		Duration: duration.String(),
+		Connect:  o.timing.Connect.String(),
+		Execute:  o.timing.Execute.String(),
+		Fetch:    o.timing.Fetch.String(),
+	}
+	var err error
+	switch config.Plan {
+	case slowQueryPlanExplain:
+		report.Plan, err = explainPlan(ctx, connection, o.statement, o.args)
+	case slowQueryPlanProfile:
+		report.Plan, err = profilePlan(ctx, connection, o)
+	}
+	if err != nil {
+		report.PlanErr = err.Error()
+	}
+	log.DefaultLogger.Warn("slow query", append(s.logFields(rc, refID),
+		"duration", duration, "connect", o.timing.Connect, "execute", o.timing.Execute, "fetch", o.timing.Fetch,
+		"rows", o.rows, "threshold", config.threshold(), "plan", strings.Join(report.Plan, "\n"), "planError", report.PlanErr)...)
+
+	notice := data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("Slow query: took %s, longer than %s (connect %s, execute %s, fetch %s)",
+			report.Duration, config.threshold(), report.Connect, report.Execute, report.Fetch),
+	}
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Notices = append(frame.Meta.Notices, notice)
+		frame.Meta.ExecutedQueryString = o.statement
+		setFrameCustom(frame, "slowQuery", report)
+	}
+}
+
+// explainPlan returns the EXPLAIN output of statement.
+func explainPlan(ctx context.Context, connection *sql.Conn, statement string, args []interface{}) ([]string, error) {
+	rows, err := connection.QueryContext(ctx, "EXPLAIN "+statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	plan := make([]string, 0)
+	for rows.Next() && len(plan) < maxPlanLines {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return plan, err
+		}
+		// a row may hold several lines of the plan
+		plan = append(plan, strings.Split(strings.TrimRight(line, "\n"), "\n")...)
+	}
+	return plan, rows.Err()
+}
+
+// profilePlan returns the execution profile of the query.
+// vertica keeps profiles for a limited time only, the plan is empty when it is gone.
+func profilePlan(ctx context.Context, connection *sql.Conn, o *queryObservation) ([]string, error) {
+	transactionID, statementID, err := o.statementIDs(ctx, connection)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := connection.QueryContext(ctx, profileQuery, transactionID, statementID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	plan := make([]string, 0)
+	for rows.Next() && len(plan) < maxPlanLines {
+		var line, runningTime string
+		var memory int64
+		if err := rows.Scan(&line, &runningTime, &memory); err != nil {
+			return plan, err
+		}
+		if runningTime != "" {
+			line = fmt.Sprintf("%s (running time %s, memory %d bytes)", line, runningTime, memory)
+		}
+		plan = append(plan, line)
+	}
+	return plan, rows.Err()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestStatementIDsBeforeCount(t *testing.T) {
+	db, connector := newFakeDB(
+		fakeStatement{match: "query_requests", result: fakeResult{
+			columns: []string{"transaction_id", "statement_id"}, types: []string{"INT", "INT"}, rows: [][]driver.Value{{int64(7), int64(3)}},
+		}},
+		fakeStatement{match: "COUNT(*)", result: fakeResult{columns: []string{"count"}, types: []string{"INT"}, rows: [][]driver.Value{{int64(1)}}}},
+		fakeStatement{match: "FROM numbers", result: fakeResult{columns: []string{"value"}, types: []string{"INT"}, rows: [][]driver.Value{{int64(1)}}}},
+	)
+	defer db.Close()
+	instance := &instanceSettings{Db: db, UID: "test"}
+	instance.config.SlowQuery = slowQueryConfig{ThresholdMs: 1, Plan: slowQueryPlanProfile}
+	statement := "SELECT value FROM numbers ORDER BY value"
+	model, err := json.Marshal(queryModel{QueryString: statement, QueryTemplated: statement, QueryType: "Table", Pagination: &queryPagination{Limit: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := backend.DataQuery{RefID: "A", JSON: model, TimeRange: backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()}}
+	if response := (&VerticaDatasource{}).query(context.Background(), requestContext{}, query, instance); response.Error != nil {
+		t.Fatalf("query failed: %v", response.Error)
+	}
+
+	order := make([]string, 0)
+	for _, statement := range connector.statements {
+		for _, match := range []string{"query_requests", "COUNT(*)"} {
+			if strings.Contains(statement, match) {
+				order = append(order, match)
+			}
+		}
+	}
+	if len(order) < 2 || order[0] != "query_requests" || order[1] != "COUNT(*)" {
+		t.Errorf("the statements ran in the order %v, the vertica ids have to be looked up before the count", order)
+	}
+}
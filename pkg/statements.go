@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+)
+
+// result sets returned by a query with several statements.
+const (
+	resultSetsAll  = "all"
+	resultSetsLast = "last"
+)
+
+// subquery returns statement as the subquery "(<statement>) AS alias" of a statement wrapping it. a trailing
+// semicolon would end the statement inside the wrapper and a trailing line comment would comment out the closing
+// parenthesis, the semicolons are trimmed and the parenthesis goes on its own line.
+func subquery(statement, alias string) string {
+	return "(\n" + trimStatement(statement) + "\n) AS " + alias
+}
+
+// trimStatement trims the whitespace and the trailing semicolons of statement.
+func trimStatement(statement string) string {
+	return strings.TrimRight(strings.TrimSpace(statement), "; \t\n")
+}
+
+// splitStatements splits sql into its statements at the semicolons outside of string literals, quoted
+// identifiers, comments and dollar quoted strings. the statements are trimmed and statements holding
+// nothing but comments are dropped.
+func splitStatements(sql string) []string {
+	statements := make([]string, 0, 1)
+	start := 0
+	for i := 0; i < len(sql); {
+		if end := skipQuoted(sql, i); end > i {
+			i = end
+			continue
+		}
+		if sql[i] == ';' {
+			statements = appendStatement(statements, sql[start:i])
+			start = i + 1
+		}
+		i++
+	}
+	return appendStatement(statements, sql[start:])
+}
+
+// skipQuoted returns the index after the string literal, quoted identifier, comment or dollar quoted string
+// starting at i, or i when none starts there.
+func skipQuoted(sql string, i int) int {
+	c := sql[i]
+	switch {
+	case c == '-' && strings.HasPrefix(sql[i:], "--"):
+		if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+			return i + end
+		}
+		return len(sql)
+	case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+		if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+			return i + end + 4
+		}
+		return len(sql)
+	case c == '"':
+		return quotedEnd(sql, i, '"', false)
+	case c == '\'':
+		// E'...' strings allow backslash escapes
+		escapes := i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+		return quotedEnd(sql, i, '\'', escapes)
+	case c == '$':
+		tag := dollarTag(sql[i:])
+		if tag == "" {
+			return i
+		}
+		if end := strings.Index(sql[i+len(tag):], tag); end >= 0 {
+			return i + len(tag) + end + len(tag)
+		}
+		return len(sql)
+	}
+	return i
+}
+
+// appendStatement appends the trimmed statement, unless it is empty or a comment.
+func appendStatement(statements []string, statement string) []string {
+	statement = strings.TrimSpace(statement)
+	if strings.TrimSpace(stripComments(redactLiterals(statement))) == "" {
+		return statements
+	}
+	return append(statements, statement)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, test := range []struct {
+		sql  string
+		want []string
+	}{
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1;", []string{"SELECT 1"}},
+		{"SELECT 1;\n SELECT 2 ; ", []string{"SELECT 1", "SELECT 2"}},
+		{"SET SEARCH_PATH TO s; SELECT 1", []string{"SET SEARCH_PATH TO s", "SELECT 1"}},
+		{"SELECT 'a;b'; SELECT 2", []string{"SELECT 'a;b'", "SELECT 2"}},
+		{"SELECT 'it''s;'; SELECT 2", []string{"SELECT 'it''s;'", "SELECT 2"}},
+		{`SELECT E'a\';b'; SELECT 2`, []string{`SELECT E'a\';b'`, "SELECT 2"}},
+		{`SELECT "a;b" FROM t; SELECT 2`, []string{`SELECT "a;b" FROM t`, "SELECT 2"}},
+		{"SELECT $$a;b$$; SELECT 2", []string{"SELECT $$a;b$$", "SELECT 2"}},
+		{"SELECT $fn$a;$$;b$fn$; SELECT 2", []string{"SELECT $fn$a;$$;b$fn$", "SELECT 2"}},
+		{"SELECT 1 -- a;b\n; SELECT 2", []string{"SELECT 1 -- a;b", "SELECT 2"}},
+		{"SELECT 1 /* a;b */; SELECT 2", []string{"SELECT 1 /* a;b */", "SELECT 2"}},
+		{"SELECT 1; -- the end;", []string{"SELECT 1"}},
+		{"SELECT 1; /* a; b */ ;", []string{"SELECT 1"}},
+		{"SELECT 'open;", []string{"SELECT 'open;"}},
+		{";;", []string{}},
+	} {
+		if got := splitStatements(test.sql); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitStatements(%q) = %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestCheckSessionStatements(t *testing.T) {
+	for statement, allowed := range map[string]bool{
+		"SELECT 1":                              true,
+		"SET SEARCH_PATH TO s":                  true,
+		"SELECT 'SET ROLE ALL'":                 true,
+		"-- SET ROLE ALL\nSELECT 1":             true,
+		"SET ROLE ALL":                          false,
+		"set  role dbadmin":                     false,
+		"SET SESSION AUTHORIZATION dbadmin":     false,
+		"/* x */ SET SESSION RESOURCE_POOL = p": false,
+	} {
+		err := checkSessionStatements([]string{"SELECT 1", statement})
+		if (err == nil) != allowed {
+			t.Errorf("checkSessionStatements(%q) = %v, allowed %v", statement, err, allowed)
+		}
+	}
+}
+
+func TestPrecedingStatementsNotRetried(t *testing.T) {
+	db, connector := newFakeDB(
+		// the connection breaks on the last statement, after the INSERT ran
+		fakeStatement{match: "SELECT 2", times: 1, result: fakeResult{err: driver.ErrBadConn}},
+		fakeStatement{match: "SELECT 2", result: fakeResult{columns: []string{"two"}, types: []string{"INT"}, rows: [][]driver.Value{{int64(2)}}}},
+	)
+	defer db.Close()
+	prepared := preparedQuery{db: db, statement: "SELECT 2", preceding: []string{"INSERT INTO t VALUES (1)"}, precedingArgs: [][]interface{}{nil}}
+	_, _, err := prepared.run(context.Background(), prepared.statement, &queryTiming{})
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("the query returned %v, want the connection error", err)
+	}
+	inserts := 0
+	for _, statement := range connector.statements {
+		if strings.HasPrefix(statement, "INSERT") {
+			inserts++
+		}
+	}
+	if inserts != 1 {
+		t.Errorf("the preceding INSERT ran %d times, want 1", inserts)
+	}
+}
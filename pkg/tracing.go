@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of the query lifecycle with the global tracer provider.
+// spans are dropped until a tracer provider with an exporter is registered with otel.SetTracerProvider.
+var tracer = otel.Tracer("rajsameer-vertica-datasource")
+
+// headerCarrier reads the trace context from the headers grafana forwards with a request.
+type headerCarrier struct {
+	rc requestContext
+}
+
+func (c headerCarrier) Get(key string) string {
+	return c.rc.header(key)
+}
+
+// Set is not used, the carrier is only extracted from.
+func (c headerCarrier) Set(key string, value string) {}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.rc.Headers))
+	for key := range c.rc.Headers {
+		keys = append(keys, strings.ToLower(key))
+	}
+	return keys
+}
+
+// traceContext returns ctx with the trace context of the grafana request as parent of the plugin spans.
+// a trace context already in ctx, e.g. put there by the plugin sdk from the grpc metadata, takes precedence.
+func traceContext(ctx context.Context, rc requestContext) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, headerCarrier{rc: rc})
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statementIDQuery returns the transaction and statement id of the last finished request of the session.
+const statementIDQuery = `SELECT transaction_id, statement_id FROM v_monitor.query_requests
+WHERE session_id = CURRENT_SESSION() AND NOT is_executing ORDER BY end_timestamp DESC LIMIT 1`
+
+// vertica ids of a query, looked up once by statementIDs.
+type statementIDs struct {
+	looked        bool
+	transactionID int64
+	statementID   int64
+	err           error
+}
+
+// statementIDs returns the vertica transaction and statement id of the query. they are looked up on the connection
+// the query ran on, before any other statement runs on it.
+func (o *queryObservation) statementIDs(ctx context.Context, connection *sql.Conn) (int64, int64, error) {
+	ids := &o.ids
+	if !ids.looked {
+		ids.looked = true
+		ids.transactionID, ids.statementID, ids.err = lastStatementIDs(ctx, connection)
+	}
+	return ids.transactionID, ids.statementID, ids.err
+}
+
+// lastStatementIDs returns the vertica transaction and statement id of the last statement which finished on the connection.
+func lastStatementIDs(ctx context.Context, connection *sql.Conn) (int64, int64, error) {
+	var transactionID, statementID int64
+	err := connection.QueryRowContext(ctx, statementIDQuery).Scan(&transactionID, &statementID)
+	return transactionID, statementID, err
+}
+
+// tagStatementIDs tags the span with the vertica transaction and statement id of the query, so the span can be
+// matched with the vertica system tables. this costs a round trip, it is only done for recorded spans.
+func tagStatementIDs(ctx context.Context, span trace.Span, connection *sql.Conn, o *queryObservation) {
+	if !span.IsRecording() {
+		return
+	}
+	transactionID, statementID, err := o.statementIDs(ctx, connection)
+	if err != nil {
+		span.AddEvent("vertica statement id unavailable: " + err.Error())
+		return
+	}
+	span.SetAttributes(
+		attribute.Int64("vertica.transaction_id", transactionID),
+		attribute.Int64("vertica.statement_id", statementID),
+	)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans replaces the tracer of the plugin with one recording the spans in memory, until the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracer
+	tracer = provider.Tracer("test")
+	t.Cleanup(func() {
+		tracer = previous
+		_ = provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+// spansNamed returns the ended spans with name, in the order they ended.
+func spansNamed(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	spans := make([]sdktrace.ReadOnlySpan, 0)
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// spanAttribute returns the attribute key of span.
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func runTracedQuery(t *testing.T, statement string, results ...fakeStatement) backend.DataResponse {
+	db, _ := newFakeDB(results...)
+	t.Cleanup(func() { db.Close() })
+	instance := &instanceSettings{Db: db, UID: "test"}
+	model, err := json.Marshal(queryModel{QueryString: statement, QueryTemplated: statement, QueryType: "Table"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := backend.DataQuery{RefID: "A", JSON: model, TimeRange: backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()}}
+	return (&VerticaDatasource{}).query(context.Background(), requestContext{}, query, instance)
+}
+
+func TestQuerySpans(t *testing.T) {
+	recorder := recordSpans(t)
+	response := runTracedQuery(t, "SELECT value FROM numbers",
+		fakeStatement{match: "FROM numbers", result: fakeResult{
+			columns: []string{"value"}, types: []string{"INT"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}},
+		}},
+		fakeStatement{match: "query_requests", result: fakeResult{
+			columns: []string{"transaction_id", "statement_id"}, types: []string{"INT", "INT"}, rows: [][]driver.Value{{int64(7), int64(3)}},
+		}},
+	)
+	if response.Error != nil {
+		t.Fatalf("query failed: %v", response.Error)
+	}
+
+	queries := spansNamed(recorder, "query")
+	if len(queries) != 1 {
+		t.Fatalf("got %d query spans, want 1", len(queries))
+	}
+	query := queries[0]
+	if query.Parent().IsValid() {
+		t.Errorf("query span has parent %s, want a root span", query.Parent().SpanID())
+	}
+	for key, want := range map[string]int64{"rows": 2, "vertica.transaction_id": 7, "vertica.statement_id": 3} {
+		if value, ok := spanAttribute(query, key); !ok || value.AsInt64() != want {
+			t.Errorf("query span attribute %s = %v, want %d", key, value.AsInterface(), want)
+		}
+	}
+	if value, _ := spanAttribute(query, "refId"); value.AsString() != "A" {
+		t.Errorf("query span refId = %q, want A", value.AsString())
+	}
+	if query.Status().Code == codes.Error {
+		t.Errorf("query span status is error: %s", query.Status().Description)
+	}
+	for _, name := range []string{"acquire connection", "QueryContext", "scan"} {
+		children := spansNamed(recorder, name)
+		if len(children) != 1 {
+			t.Fatalf("got %d %s spans, want 1", len(children), name)
+		}
+		if children[0].Parent().SpanID() != query.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of the query span", name)
+		}
+	}
+	if value, _ := spanAttribute(spansNamed(recorder, "scan")[0], "rows"); value.AsInt64() != 2 {
+		t.Errorf("scan span rows = %d, want 2", value.AsInt64())
+	}
+	if value, _ := spanAttribute(spansNamed(recorder, "acquire connection")[0], "attempt"); value.AsInt64() != 0 {
+		t.Errorf("acquire connection attempt = %d, want 0", value.AsInt64())
+	}
+}
+
+func TestQuerySpanError(t *testing.T) {
+	recorder := recordSpans(t)
+	syntaxError := errors.New("Syntax error at or near \"SELEC\"")
+	response := runTracedQuery(t, "SELEC 1", fakeStatement{match: "SELEC 1", result: fakeResult{err: syntaxError}})
+	if response.Error == nil {
+		t.Fatal("query succeeded, want the syntax error")
+	}
+	for _, name := range []string{"query", "QueryContext"} {
+		spans := spansNamed(recorder, name)
+		if len(spans) != 1 {
+			t.Fatalf("got %d %s spans, want 1", len(spans), name)
+		}
+		if status := spans[0].Status(); status.Code != codes.Error || status.Description != syntaxError.Error() {
+			t.Errorf("%s span status = %v %q, want error %q", name, status.Code, status.Description, syntaxError)
+		}
+	}
+	if len(spansNamed(recorder, "scan")) != 0 {
+		t.Error("a failed query has a scan span")
+	}
+}
+
+func TestQueryWithReconnectSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	db, _ := newFakeDB(
+		// the first connection is broken, the query is retried on a new one
+		fakeStatement{match: "SELECT 1", times: 1, result: fakeResult{err: driver.ErrBadConn}},
+		fakeStatement{match: "SELECT 1", result: fakeResult{columns: []string{"one"}, types: []string{"INT"}, rows: [][]driver.Value{{int64(1)}}}},
+	)
+	defer db.Close()
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	connection, rows, err := queryWithReconnect(ctx, db, "SELECT 1", nil, true, &queryTiming{})
+	parent.End()
+	if err != nil {
+		t.Fatalf("query failed after reconnecting: %v", err)
+	}
+	rows.Close()
+	connection.Close()
+
+	acquires := spansNamed(recorder, "acquire connection")
+	if len(acquires) != 2 {
+		t.Fatalf("got %d acquire connection spans, want 2", len(acquires))
+	}
+	for attempt, span := range acquires {
+		if value, _ := spanAttribute(span, "attempt"); value.AsInt64() != int64(attempt) {
+			t.Errorf("acquire connection span %d has attempt %d", attempt, value.AsInt64())
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("acquire connection span %d is not a child of the parent span", attempt)
+		}
+	}
+	queries := spansNamed(recorder, "QueryContext")
+	if len(queries) != 2 {
+		t.Fatalf("got %d QueryContext spans, want 2", len(queries))
+	}
+	if queries[0].Status().Code != codes.Error {
+		t.Error("QueryContext span of the broken connection has no error status")
+	}
+	if queries[1].Status().Code == codes.Error {
+		t.Errorf("QueryContext span of the retry has error status %q", queries[1].Status().Description)
+	}
+}
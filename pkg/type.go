@@ -2,25 +2,177 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
 type datasourceConfig struct {
-	Database                   string `json:"database"`
-	Host                       string `json:"host"`
-	UseConnectionLoadbalancing bool   `json:"useConnectionLoadbalancing"`
-	UsePreparedStatement       bool   `json:"usePreparedStatement"`
-	User                       string `json:"user"`
-	SSlMode                    string `json:"sslMode,omitempty"`
-	MaxOpenConnections         int    `json:"maxOpenConnections"`
-	MaxIdealConnections        int    `json:"maxIdealConnections"`
-	MaxConnectionIdealTime     int    `json:"maxConnectionIdealTime"`
+	Database                   string      `json:"database"`
+	Host                       string      `json:"host"`
+	BackupHosts                []string    `json:"backupHosts,omitempty"`
+	UseConnectionLoadbalancing bool        `json:"useConnectionLoadbalancing"`
+	UsePreparedStatement       bool        `json:"usePreparedStatement"`
+	User                       string      `json:"user"`
+	AuthType                   string      `json:"authType,omitempty"`
+	OAuth                      oauthConfig `json:"oauth,omitempty"`
+	// PasswordSource reads a rotating password from a file or environment variable, see credentials.go
+	PasswordSource         passwordSource `json:"passwordSource,omitempty"`
+	SSlMode                string         `json:"sslMode,omitempty"`
+	MaxOpenConnections     int            `json:"maxOpenConnections"`
+	MaxIdealConnections    int            `json:"maxIdealConnections"`
+	MaxConnectionIdealTime int            `json:"maxConnectionIdealTime"`
+	// MaxConnectionLifetime is in minutes, like MaxConnectionIdealTime
+	MaxConnectionLifetime int `json:"maxConnectionLifetime,omitempty"`
+	// Workload is the vertica workload connections are opened with, queries may choose one of AllowedWorkloads
+	Workload             string   `json:"workload,omitempty"`
+	AllowedWorkloads     []string `json:"allowedWorkloads,omitempty"`
+	AllowedResourcePools []string `json:"allowedResourcePools,omitempty"`
+	// UserIdentity forwards the grafana user to vertica, see identity.go
+	UserIdentity userIdentityConfig `json:"userIdentity,omitempty"`
+	// QueryLabel tags every query with a label built from the grafana request, see label.go
+	QueryLabel queryLabelConfig `json:"queryLabel,omitempty"`
+	// SessionParameters and InitStatements are applied to every new connection, see session.go
+	SessionParameters sessionParameters `json:"sessionParameters,omitempty"`
+	InitStatements    []string          `json:"initStatements,omitempty"`
+	// AuditLog records the executed statements, see logging.go
+	AuditLog auditLogConfig `json:"auditLog,omitempty"`
+	// SlowQuery reports queries running longer than a threshold, see slowquery.go
+	SlowQuery slowQueryConfig `json:"slowQuery,omitempty"`
+	// AllowProfile allows the /explain route to run queries with PROFILE, see explain.go
+	AllowProfile bool `json:"allowProfile,omitempty"`
+	// Export limits the rows of the /export route, see export.go
+	Export exportConfig `json:"export,omitempty"`
+	// Cursor configures the server side cursors of the /cursor routes, see cursor.go
+	Cursor cursorConfig `json:"cursor,omitempty"`
 }
 
-func (config *datasourceConfig) ConnectionURL(password string) string {
+// defaultVerticaPort is used when the configured host does not carry a port.
+const defaultVerticaPort = "5433"
+
+// default pool settings, used when a setting is missing or out of range.
+// the idle time and lifetime are in minutes.
+const (
+	defaultMaxOpenConnections     = 10
+	defaultMaxIdealConnections    = 2
+	defaultMaxConnectionIdealTime = 5
+	defaultMaxConnectionLifetime  = 60
+)
+
+// applyPoolDefaults replaces missing or out of range pool settings with defaults and returns a warning for each of them.
+// a zero max open connections would mean an unlimited pool, which can exhaust the connection limit of vertica.
+func (config *datasourceConfig) applyPoolDefaults() []string {
+	var warnings []string
+	if config.MaxOpenConnections <= 0 {
+		warnings = append(warnings, fmt.Sprintf("maxOpenConnections %d is invalid, using %d", config.MaxOpenConnections, defaultMaxOpenConnections))
+		config.MaxOpenConnections = defaultMaxOpenConnections
+	}
+	if config.MaxIdealConnections <= 0 {
+		idle := defaultMaxIdealConnections
+		if idle > config.MaxOpenConnections {
+			idle = config.MaxOpenConnections
+		}
+		warnings = append(warnings, fmt.Sprintf("maxIdealConnections %d is invalid, using %d", config.MaxIdealConnections, idle))
+		config.MaxIdealConnections = idle
+	}
+	if config.MaxIdealConnections > config.MaxOpenConnections {
+		warnings = append(warnings, fmt.Sprintf("maxIdealConnections %d is larger than maxOpenConnections, using %d", config.MaxIdealConnections, config.MaxOpenConnections))
+		config.MaxIdealConnections = config.MaxOpenConnections
+	}
+	if config.MaxConnectionIdealTime <= 0 {
+		warnings = append(warnings, fmt.Sprintf("maxConnectionIdealTime %d is invalid, using %d minutes", config.MaxConnectionIdealTime, defaultMaxConnectionIdealTime))
+		config.MaxConnectionIdealTime = defaultMaxConnectionIdealTime
+	}
+	if config.MaxConnectionLifetime < 0 {
+		warnings = append(warnings, fmt.Sprintf("maxConnectionLifetime %d is invalid, using %d minutes", config.MaxConnectionLifetime, defaultMaxConnectionLifetime))
+	}
+	if config.MaxConnectionLifetime <= 0 {
+		// data sources created before the setting existed do not carry it
+		config.MaxConnectionLifetime = defaultMaxConnectionLifetime
+	}
+	return warnings
+}
+
+// connMaxLifetime is the time after which a connection is closed, even when in regular use.
+func (config datasourceConfig) connMaxLifetime() time.Duration {
+	return time.Minute * time.Duration(config.MaxConnectionLifetime)
+}
+
+// authentication types, password authentication is used when none is configured.
+const (
+	authTypePassword = "password"
+	authTypeKerberos = "kerberos"
+	authTypeOAuth    = "oauth"
+)
+
+// validateAuth checks the authentication type is one the vertica driver supports and is completely configured.
+func (config *datasourceConfig) validateAuth() error {
+	switch config.AuthType {
+	case "", authTypePassword:
+		if config.User == "" {
+			return errors.New("user is required")
+		}
+		return nil
+	case authTypeOAuth:
+		if config.OAuth.ForwardUserToken {
+			if config.UserIdentity.Mode == identityModeConnection {
+				return errors.New("oauth token forwarding cannot be combined with user identity mode connection")
+			}
+			return nil
+		}
+		if config.OAuth.TokenURL == "" || config.OAuth.ClientID == "" {
+			return errors.New("oauth token url and client id are required")
+		}
+		return nil
+	case authTypeKerberos:
+		// the vertica-sql-go driver only implements password authentication messages, no GSSAPI.
+		return errors.New("kerberos authentication is not supported by the vertica go driver, use password authentication")
+	default:
+		return fmt.Errorf("unknown authentication type %q", config.AuthType)
+	}
+}
+
+// Hosts returns the primary host followed by the backup hosts, normalized to host:port and without duplicates.
+func (config *datasourceConfig) Hosts() ([]string, error) {
+	hosts := make([]string, 0, len(config.BackupHosts)+1)
+	seen := make(map[string]bool)
+	for i, hostPort := range append([]string{config.Host}, config.BackupHosts...) {
+		if i > 0 && strings.TrimSpace(hostPort) == "" {
+			continue
+		}
+		host, err := normalizeHostPort(hostPort)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// ConnectionURL builds the vertica driver DSN for one of the configured hosts.
+// every component is escaped with net/url so credentials containing reserved characters
+// such as '@', '/', '?' or '%' do not change the meaning of the DSN.
+// with an oauth access token the user is optional, vertica takes the user from the token.
+func (config *datasourceConfig) ConnectionURL(hostPort string, creds credentials) (string, error) {
+	if config.User == "" && creds.AccessToken == "" {
+		return "", errors.New("user is required")
+	}
+	if strings.Contains(config.Database, "/") {
+		return "", fmt.Errorf("invalid database %q: database name cannot contain '/'", config.Database)
+	}
+	host, err := normalizeHostPort(hostPort)
+	if err != nil {
+		return "", err
+	}
 	var tlsmode string
 	if config.SSlMode == "" {
 		tlsmode = "none"
@@ -28,8 +180,67 @@ func (config *datasourceConfig) ConnectionURL(password string) string {
 		tlsmode = config.SSlMode
 	}
 
-	return fmt.Sprintf("vertica://%s:%s@%s/%s?use_prepared_statements=%d&connection_load_balance=%d&tlsmode=%s",
-		config.User, password, config.Host, config.Database, boolToUint8(config.UsePreparedStatement), boolToUint8(config.UseConnectionLoadbalancing), tlsmode)
+	query := url.Values{}
+	query.Set("use_prepared_statements", strconv.Itoa(int(boolToUint8(config.UsePreparedStatement))))
+	query.Set("connection_load_balance", strconv.Itoa(int(boolToUint8(config.UseConnectionLoadbalancing))))
+	query.Set("tlsmode", tlsmode)
+	if config.Workload != "" {
+		query.Set("workload", config.Workload)
+	}
+
+	dsn := url.URL{
+		Scheme: "vertica",
+		Host:   host,
+		Path:   "/" + config.Database,
+	}
+	if creds.AccessToken != "" {
+		query.Set("oauth_access_token", creds.AccessToken)
+		if config.User != "" {
+			dsn.User = url.User(config.User)
+		}
+	} else {
+		dsn.User = url.UserPassword(config.User, creds.Password)
+	}
+	dsn.RawQuery = query.Encode()
+	return dsn.String(), nil
+}
+
+// normalizeHostPort validates a host or host:port pair and returns it in a form usable as the DSN host.
+// IPv6 literals are accepted with or without brackets, the vertica default port is used when none is given.
+func normalizeHostPort(hostPort string) (string, error) {
+	hostPort = strings.TrimSpace(hostPort)
+	if hostPort == "" {
+		return "", errors.New("host is required")
+	}
+	host, port := hostPort, defaultVerticaPort
+	switch {
+	case net.ParseIP(strings.Trim(hostPort, "[]")) != nil:
+		// bare IPv4 or IPv6 literal without a port
+		if strings.HasPrefix(hostPort, "[") != strings.HasSuffix(hostPort, "]") {
+			return "", fmt.Errorf("invalid host %q: unbalanced brackets", hostPort)
+		}
+		host = strings.Trim(hostPort, "[]")
+	default:
+		h, p, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			if !strings.Contains(err.Error(), "missing port") {
+				return "", fmt.Errorf("invalid host %q: %w", hostPort, err)
+			}
+		} else {
+			host, port = h, p
+		}
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid host %q: missing host name", hostPort)
+	}
+	if strings.ContainsAny(host, "/?#@ ") {
+		return "", fmt.Errorf("invalid host %q: host name contains reserved characters", hostPort)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		return "", fmt.Errorf("invalid host %q: port must be a number between 1 and 65535", hostPort)
+	}
+	return net.JoinHostPort(host, port), nil
 }
 
 func boolToUint8(x bool) int8 {
@@ -266,3 +477,61 @@ func generateRowIn(columnTypes []*sql.ColumnType) []interface{} {
 	}
 	return rowIn
 }
+
+// generateNullableRowIn returns scan targets of the types of generateRowIn which also hold NULL values,
+// the scanned values are read with scannedValue.
+func generateNullableRowIn(columnTypes []*sql.ColumnType) []interface{} {
+	rowIn := generateRowIn(columnTypes)
+	for i, value := range rowIn {
+		switch value.(type) {
+		case *bool:
+			rowIn[i] = &sql.NullBool{}
+		case *int64:
+			rowIn[i] = &sql.NullInt64{}
+		case *float64:
+			rowIn[i] = &sql.NullFloat64{}
+		case *time.Time:
+			rowIn[i] = &sql.NullTime{}
+		default:
+			rowIn[i] = &sql.NullString{}
+		}
+	}
+	return rowIn
+}
+
+// scannedValue returns the value scanned into a target of generateNullableRowIn as a pointer of the type
+// generateRowIn scans into, or nil for NULL. the pointer is into the scan target.
+func scannedValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *sql.NullBool:
+		if v.Valid {
+			return &v.Bool
+		}
+	case *sql.NullInt64:
+		if v.Valid {
+			return &v.Int64
+		}
+	case *sql.NullFloat64:
+		if v.Valid {
+			return &v.Float64
+		}
+	case *sql.NullTime:
+		if v.Valid {
+			return &v.Time
+		}
+	case *sql.NullString:
+		if v.Valid {
+			return &v.String
+		}
+	default:
+		return value
+	}
+	return nil
+}
+
+// scannedValues sets values to the values scanned into rowIn, see scannedValue.
+func scannedValues(rowIn []interface{}, values []interface{}) {
+	for i, value := range rowIn {
+		values[i] = scannedValue(value)
+	}
+}
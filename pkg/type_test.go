@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestConnectionURL(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		config   datasourceConfig
+		host     string
+		creds    credentials
+		user     string
+		password string
+		hostPort string
+		query    url.Values
+	}{
+		{
+			name:     "reserved characters in the password",
+			config:   datasourceConfig{Database: "db", User: "dbadmin"},
+			host:     "vertica",
+			creds:    credentials{Password: "p@ss%20/w?rd#1:"},
+			user:     "dbadmin",
+			password: "p@ss%20/w?rd#1:",
+			hostPort: "vertica:5433",
+			query:    url.Values{"use_prepared_statements": {"0"}, "connection_load_balance": {"0"}, "tlsmode": {"none"}},
+		},
+		{
+			name:     "reserved characters in the user",
+			config:   datasourceConfig{Database: "db", User: "dom@in\\user", SSlMode: "server", Workload: "etl&x=1", UsePreparedStatement: true},
+			host:     "10.0.0.1:5444",
+			creds:    credentials{Password: "%"},
+			user:     "dom@in\\user",
+			password: "%",
+			hostPort: "10.0.0.1:5444",
+			query:    url.Values{"use_prepared_statements": {"1"}, "connection_load_balance": {"0"}, "tlsmode": {"server"}, "workload": {"etl&x=1"}},
+		},
+		{
+			name:     "oauth token",
+			config:   datasourceConfig{Database: "db"},
+			host:     "[::1]:5433",
+			creds:    credentials{AccessToken: "a+b/c=="},
+			hostPort: "[::1]:5433",
+			query:    url.Values{"use_prepared_statements": {"0"}, "connection_load_balance": {"0"}, "tlsmode": {"none"}, "oauth_access_token": {"a+b/c=="}},
+		},
+	} {
+		dsn, err := test.config.ConnectionURL(test.host, test.creds)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		parsed, err := url.Parse(dsn)
+		if err != nil {
+			t.Errorf("%s: the dsn %s does not parse: %v", test.name, dsn, err)
+			continue
+		}
+		password, _ := parsed.User.Password()
+		if parsed.User.Username() != test.user || password != test.password {
+			t.Errorf("%s: the dsn %s has the user %q and password %q, want %q and %q", test.name, dsn, parsed.User.Username(), password, test.user, test.password)
+		}
+		if parsed.Host != test.hostPort || parsed.Path != "/db" {
+			t.Errorf("%s: the dsn %s has the host %q and path %q, want %q and /db", test.name, dsn, parsed.Host, parsed.Path, test.hostPort)
+		}
+		if parsed.Query().Encode() != test.query.Encode() {
+			t.Errorf("%s: the dsn %s has the options %s, want %s", test.name, dsn, parsed.Query().Encode(), test.query.Encode())
+		}
+	}
+
+	if _, err := (&datasourceConfig{Database: "db"}).ConnectionURL("vertica", credentials{Password: "p"}); err == nil {
+		t.Error("a dsn without user was built")
+	}
+	if _, err := (&datasourceConfig{Database: "a/b", User: "u"}).ConnectionURL("vertica", credentials{}); err == nil {
+		t.Error("a dsn with a database containing / was built")
+	}
+}
+
+func TestNormalizeHostPort(t *testing.T) {
+	for hostPort, want := range map[string]string{
+		"vertica":                         "vertica:5433",
+		" vertica:5444 ":                  "vertica:5444",
+		"10.0.0.1":                        "10.0.0.1:5433",
+		"10.0.0.1:5444":                   "10.0.0.1:5444",
+		"::1":                             "[::1]:5433",
+		"[::1]":                           "[::1]:5433",
+		"[::1]:5444":                      "[::1]:5444",
+		"fe80::1:2":                       "[fe80::1:2]:5433",
+		"[2001:db8::8a2e:370:7334]:65535": "[2001:db8::8a2e:370:7334]:65535",
+		"":                                "",
+		":5433":                           "",
+		"vertica:0":                       "",
+		"vertica:65536":                   "",
+		"vertica:port":                    "",
+		"user@vertica":                    "",
+		"vertica/db":                      "",
+		"[::1":                            "",
+	} {
+		got, err := normalizeHostPort(hostPort)
+		if want == "" {
+			if err == nil {
+				t.Errorf("normalizeHostPort(%q) = %q, want an error", hostPort, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("normalizeHostPort(%q) = %q, %v, want %q", hostPort, got, err, want)
+		}
+	}
+}
+
+func TestApplyPoolDefaults(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		config   datasourceConfig
+		want     datasourceConfig
+		warnings int
+	}{
+		{
+			name:   "valid settings",
+			config: datasourceConfig{MaxOpenConnections: 20, MaxIdealConnections: 5, MaxConnectionIdealTime: 10, MaxConnectionLifetime: 30},
+			want:   datasourceConfig{MaxOpenConnections: 20, MaxIdealConnections: 5, MaxConnectionIdealTime: 10, MaxConnectionLifetime: 30},
+		},
+		{
+			name:     "missing settings",
+			config:   datasourceConfig{},
+			want:     datasourceConfig{MaxOpenConnections: 10, MaxIdealConnections: 2, MaxConnectionIdealTime: 5, MaxConnectionLifetime: 60},
+			warnings: 3,
+		},
+		{
+			name:     "negative settings",
+			config:   datasourceConfig{MaxOpenConnections: -1, MaxIdealConnections: -1, MaxConnectionIdealTime: -1, MaxConnectionLifetime: -1},
+			want:     datasourceConfig{MaxOpenConnections: 10, MaxIdealConnections: 2, MaxConnectionIdealTime: 5, MaxConnectionLifetime: 60},
+			warnings: 4,
+		},
+		{
+			name:     "missing idle connections above the open connections",
+			config:   datasourceConfig{MaxOpenConnections: 1, MaxConnectionIdealTime: 5, MaxConnectionLifetime: 60},
+			want:     datasourceConfig{MaxOpenConnections: 1, MaxIdealConnections: 1, MaxConnectionIdealTime: 5, MaxConnectionLifetime: 60},
+			warnings: 1,
+		},
+		{
+			name:     "idle connections above the open connections",
+			config:   datasourceConfig{MaxOpenConnections: 4, MaxIdealConnections: 8, MaxConnectionIdealTime: 5, MaxConnectionLifetime: 60},
+			want:     datasourceConfig{MaxOpenConnections: 4, MaxIdealConnections: 4, MaxConnectionIdealTime: 5, MaxConnectionLifetime: 60},
+			warnings: 1,
+		},
+		{
+			// data sources saved before the lifetime setting existed get the default without a warning
+			name:   "missing lifetime",
+			config: datasourceConfig{MaxOpenConnections: 10, MaxIdealConnections: 2, MaxConnectionIdealTime: 5},
+			want:   datasourceConfig{MaxOpenConnections: 10, MaxIdealConnections: 2, MaxConnectionIdealTime: 5, MaxConnectionLifetime: 60},
+		},
+	} {
+		config := test.config
+		warnings := config.applyPoolDefaults()
+		if config.MaxOpenConnections != test.want.MaxOpenConnections || config.MaxIdealConnections != test.want.MaxIdealConnections ||
+			config.MaxConnectionIdealTime != test.want.MaxConnectionIdealTime || config.MaxConnectionLifetime != test.want.MaxConnectionLifetime {
+			t.Errorf("%s: got the pool settings %d/%d/%d/%d, want %d/%d/%d/%d", test.name,
+				config.MaxOpenConnections, config.MaxIdealConnections, config.MaxConnectionIdealTime, config.MaxConnectionLifetime,
+				test.want.MaxOpenConnections, test.want.MaxIdealConnections, test.want.MaxConnectionIdealTime, test.want.MaxConnectionLifetime)
+		}
+		if len(warnings) != test.warnings {
+			t.Errorf("%s: got the warnings %q, want %d", test.name, warnings, test.warnings)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// variableRequest is the body of the /variable route, the query model of the variable query with the
+// search term typed in the variable picker and the value of the expanded parent, if any.
+type variableRequest struct {
+	queryModel
+	Search string `json:"search,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	// Sort is the sort order of the variable, with the values of grafana's VariableSort
+	Sort int `json:"sort,omitempty"`
+}
+
+// variableValue is a value of a template variable, as returned by metricFindQuery.
+type variableValue struct {
+	Text       string `json:"text"`
+	Value      string `json:"value"`
+	Expandable bool   `json:"expandable,omitempty"`
+}
+
+// sort orders of template variables, the values of grafana's VariableSort.
+const (
+	variableSortDisabled = iota
+	variableSortAlphabeticalAsc
+	variableSortAlphabeticalDesc
+	variableSortNumericalAsc
+	variableSortNumericalDesc
+	variableSortAlphabeticalCaseInsensitiveAsc
+	variableSortAlphabeticalCaseInsensitiveDesc
+)
+
+// columns of a variable query with a special meaning.
+const (
+	variableTextColumn       = "_text"
+	variableValueColumn      = "_value"
+	variableExpandableColumn = "_expandable"
+)
+
+// handleVariable returns the values of a template variable query.
+func (td *VerticaDatasource) handleVariable(w http.ResponseWriter, r *http.Request) {
+	rc, instance, err := td.resourceRequest(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var req variableRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	values, err := instance.variableValues(r.Context(), rc, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, values)
+}
+
+// variableValues runs a variable query and returns its values. the text of a value is the _text column,
+// or the first column, the value is the _value column, or the text. a boolean _expandable column marks
+// values with children, which are queried with the value as $__parent.
+// $__search and $__parent are replaced with the search term and the parent value as string literals,
+// the values are also filtered by the search term, deduplicated and sorted.
+func (s *instanceSettings) variableValues(ctx context.Context, rc requestContext, req variableRequest) ([]variableValue, error) {
+	qm := req.queryModel
+	qm.QueryTemplated = strings.NewReplacer(
+		"$__search", quoteLiteral(req.Search),
+		"$__parent", quoteLiteral(req.Parent),
+	).Replace(qm.QueryTemplated)
+	refID := qm.RefId
+	if refID == "" {
+		refID = "Var"
+	}
+	prepared, err := s.prepareQuery(rc, qm, refID)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	connection, rows, err := prepared.run(ctx, prepared.statement, &queryTiming{})
+	if err != nil {
+		s.audit(rc, refID, prepared.statement, err, errorClassQuery, time.Since(start), 0)
+		return nil, err
+	}
+	defer s.releasePrepared(connection, prepared)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	textIdx, valueIdx, expandableIdx := 0, -1, -1
+	for i, column := range columns {
+		switch strings.ToLower(column) {
+		case variableTextColumn:
+			textIdx = i
+		case variableValueColumn:
+			valueIdx = i
+		case variableExpandableColumn:
+			expandableIdx = i
+		}
+	}
+	values := make([]variableValue, 0)
+	seen := make(map[variableValue]bool)
+	search := strings.ToLower(req.Search)
+	count := 0
+	rowIn := generateNullableRowIn(columnTypes)
+	row := make([]interface{}, len(rowIn))
+	for rows.Next() {
+		if err := rows.Scan(rowIn...); err != nil {
+			s.audit(rc, refID, prepared.statement, err, errorClassConversion, time.Since(start), count)
+			return nil, err
+		}
+		count++
+		scannedValues(rowIn, row)
+		// a NULL text is no option, a NULL value falls back to the text and a NULL expandable is false
+		if row[textIdx] == nil {
+			continue
+		}
+		value := variableValue{Text: valueString(row[textIdx])}
+		value.Value = value.Text
+		if valueIdx >= 0 && row[valueIdx] != nil {
+			value.Value = valueString(row[valueIdx])
+		}
+		if expandableIdx >= 0 {
+			value.Expandable, _ = strconv.ParseBool(valueString(row[expandableIdx]))
+		}
+		if search != "" && !strings.Contains(strings.ToLower(value.Text), search) {
+			continue
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	err = rows.Err()
+	s.audit(rc, refID, prepared.statement, err, errorClassQuery, time.Since(start), count)
+	if err != nil {
+		return nil, err
+	}
+	sortVariableValues(values, req.Sort)
+	return values, nil
+}
+
+// sortVariableValues sorts values by their text in the sort order of the variable.
+// numerical sorting uses the first number in the text, texts without one sort first.
+func sortVariableValues(values []variableValue, order int) {
+	var less func(a, b variableValue) bool
+	switch order {
+	case variableSortAlphabeticalAsc:
+		less = func(a, b variableValue) bool { return a.Text < b.Text }
+	case variableSortAlphabeticalDesc:
+		less = func(a, b variableValue) bool { return a.Text > b.Text }
+	case variableSortNumericalAsc:
+		less = func(a, b variableValue) bool { return firstNumber(a.Text) < firstNumber(b.Text) }
+	case variableSortNumericalDesc:
+		less = func(a, b variableValue) bool { return firstNumber(a.Text) > firstNumber(b.Text) }
+	case variableSortAlphabeticalCaseInsensitiveAsc:
+		less = func(a, b variableValue) bool { return strings.ToLower(a.Text) < strings.ToLower(b.Text) }
+	case variableSortAlphabeticalCaseInsensitiveDesc:
+		less = func(a, b variableValue) bool { return strings.ToLower(a.Text) > strings.ToLower(b.Text) }
+	default:
+		return
+	}
+	sort.SliceStable(values, func(i, j int) bool { return less(values[i], values[j]) })
+}
+
+// firstNumber returns the first integer in text, like grafana does for numerical variable sorting.
+func firstNumber(text string) float64 {
+	start := strings.IndexAny(text, "0123456789")
+	if start < 0 {
+		return -1
+	}
+	end := start
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+	number, _ := strconv.ParseFloat(text[start:end], 64)
+	return number
+}
+
+// valueString formats a value scanned by generateRowIn as text, NULL values, see scannedValue, as an empty text.
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case *string:
+		return *v
+	case *int64:
+		return strconv.FormatInt(*v, 10)
+	case *float64:
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestVariableValuesNulls(t *testing.T) {
+	db, _ := newFakeDB(fakeStatement{match: "FROM nodes", result: fakeResult{
+		columns: []string{"_text", "_value", "_expandable"},
+		types:   []string{"VARCHAR", "VARCHAR", "BOOLEAN"},
+		rows: [][]driver.Value{
+			{"a", "1", true},
+			{nil, "2", false},
+			{"c", nil, nil},
+		},
+	}})
+	defer db.Close()
+	instance := &instanceSettings{Db: db, UID: "test"}
+	statement := "SELECT _text, _value, _expandable FROM nodes"
+	values, err := instance.variableValues(context.Background(), requestContext{}, variableRequest{queryModel: queryModel{QueryString: statement, QueryTemplated: statement}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []variableValue{{Text: "a", Value: "1", Expandable: true}, {Text: "c", Value: "c"}}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("the values are %+v, want %+v", values, want)
+	}
+}
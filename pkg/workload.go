@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// connectionPool is a pool of connections opened for another workload or a forwarded user identity.
+type connectionPool struct {
+	db *sql.DB
+	// token is the forwarded oauth token of the user the pool belongs to
+	token *forwardedToken
+}
+
+// resetTimeout bounds the statement restoring the session of a connection before it goes back to the pool.
+const resetTimeout = 10 * time.Second
+
+// isAllowed reports whether name is in the allowlist, vertica object names are case insensitive.
+func isAllowed(allowlist []string, name string) bool {
+	for _, allowed := range allowlist {
+		if strings.EqualFold(strings.TrimSpace(allowed), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRouting checks the resource pool and workload requested by a query against the data source allowlists.
+// a data source without allowlist does not allow queries to choose a pool or workload.
+func (config *datasourceConfig) validateRouting(qm queryModel) error {
+	if qm.ResourcePool != "" && !isAllowed(config.AllowedResourcePools, qm.ResourcePool) {
+		return fmt.Errorf("resource pool %q is not allowed for this data source", qm.ResourcePool)
+	}
+	if qm.Workload != "" && !strings.EqualFold(qm.Workload, config.Workload) && !isAllowed(config.AllowedWorkloads, qm.Workload) {
+		return fmt.Errorf("workload %q is not allowed for this data source", qm.Workload)
+	}
+	return nil
+}
+
+// connectionPool returns the connection pool used for a workload and vertica identity.
+// vertica routes a connection to a subcluster when the connection is opened, so every workload
+// needs its own pool of connections, opened with the workload connection property.
+// likewise queries forwarding the grafana user identity need connections logged in as that user.
+func (s *instanceSettings) connectionPool(workload string, identity *verticaIdentity) (*sql.DB, error) {
+	if strings.EqualFold(workload, s.config.Workload) {
+		workload = ""
+	}
+	if workload == "" && identity == nil {
+		return s.Db, nil
+	}
+	config := s.config
+	var credentials credentialProvider = s.connector.credentials
+	key := strings.ToLower(workload) + "/"
+	if workload != "" {
+		config.Workload = workload
+	}
+	if identity != nil {
+		config.User = identity.User
+		key += identity.key()
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	pool, ok := s.pools[key]
+	observeCache(s.UID, cachePool, ok)
+	if ok {
+		if identity != nil && identity.AccessToken != "" {
+			pool.token.set(identity.AccessToken)
+		}
+		return pool.db, nil
+	}
+	pool = &connectionPool{}
+	if identity != nil {
+		credentials = staticCredentials{Password: identity.Password}
+		if identity.AccessToken != "" {
+			pool.token = &forwardedToken{}
+			pool.token.set(identity.AccessToken)
+			credentials = pool.token
+		}
+	}
+	connector, err := newFailoverConnector(config, credentials, s.connector.sessionTemplate())
+	if err != nil {
+		return nil, err
+	}
+	pool.db = sql.OpenDB(connector)
+	configurePool(pool.db, config)
+	if s.pools == nil {
+		s.pools = make(map[string]*connectionPool)
+	}
+	s.pools[key] = pool
+	if identity == nil {
+		// pools of forwarded identities are left out of the metrics, a series per grafana user is too many
+		poolStats.add(pool.db, poolLabels{Datasource: s.Name, DatasourceUID: s.UID, Pool: "workload:" + strings.ToLower(workload)})
+	}
+	log.DefaultLogger.Info("connection pool created", "datasource", s.Name, "workload", workload, "identity", identity.key())
+	return pool.db, nil
+}
+
+// resourcePoolStatements returns the statements moving the session to the resource pool of the query
+// and the statements restoring the session before the connection goes back to the pool.
+func (s *instanceSettings) resourcePoolStatements(qm queryModel) ([]string, []string) {
+	if qm.ResourcePool == "" {
+		return nil, nil
+	}
+	reset := "SET SESSION RESOURCE_POOL = DEFAULT"
+	if pool := s.config.SessionParameters.ResourcePool; pool != "" {
+		reset = "SET SESSION RESOURCE_POOL = " + quoteIdentifier(s.connector.sessionTemplate().replace(pool))
+	}
+	return []string{"SET SESSION RESOURCE_POOL = " + quoteIdentifier(qm.ResourcePool)}, []string{reset}
+}
+
+// releasePrepared releases the connection of a prepared query. the connection of a query with several statements
+// is discarded, its statements may have changed the session in ways the reset statements do not restore.
+func (s *instanceSettings) releasePrepared(connection *sql.Conn, prepared preparedQuery) {
+	if len(prepared.preceding) > 0 {
+		discardConnection(connection)
+		return
+	}
+	s.releaseConnection(connection, prepared.reset)
+}
+
+// releaseConnection runs the reset statements before the connection goes back to the pool.
+// the connection is discarded when the session cannot be restored.
+func (s *instanceSettings) releaseConnection(connection *sql.Conn, reset []string) {
+	if len(reset) == 0 {
+		connection.Close()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+	defer cancel()
+	if err := runSessionStatements(ctx, connection, reset); err != nil {
+		log.DefaultLogger.Warn("session reset failed, discarding connection", "error", err.Error())
+		discardConnection(connection)
+		return
+	}
+	connection.Close()
+}
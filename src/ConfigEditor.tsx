@@ -81,6 +81,15 @@ export class ConfigEditor extends PureComponent<Props, State> {
     onOptionsChange({ ...options, jsonData });
   };
 
+  onMaxConnectionLifetimeChange = (value: number) => {
+    const { onOptionsChange, options } = this.props,
+      jsonData = {
+        ...options.jsonData,
+        maxConnectionLifetime: value,
+      };
+    onOptionsChange({ ...options, jsonData });
+  };
+
   onDatabaseChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onOptionsChange, options } = this.props,
       jsonData = {
@@ -275,6 +284,21 @@ export class ConfigEditor extends PureComponent<Props, State> {
             </Field>
           </div>
         </div>
+        <div className="gf-form-inline">
+          <div className="gf-form">
+            <Field
+              label="Max Connection Lifetime"
+              description="Set max time a connection is reused, in minutes. Defaults to 60 minutes"
+            >
+              <Slider
+                min={0}
+                max={999}
+                onChange={this.onMaxConnectionLifetimeChange}
+                value={jsonData.maxConnectionLifetime || 0}
+              />
+            </Field>
+          </div>
+        </div>
         <div className="gf-form-inline">
           <div className="gf-form">
             <Field
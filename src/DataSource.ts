@@ -1,4 +1,5 @@
 import {
+  AnnotationQuery,
   CircularDataFrame,
   DataFrame,
   DataQueryRequest,
@@ -6,93 +7,265 @@ import {
   DataSourceInstanceSettings,
   Field,
   LoadingState,
+  LogRowModel,
   MetricFindValue,
   ScopedVars,
+  TimeRange,
 } from '@grafana/data';
-import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
-import { VerticaDataSourceOptions, VerticaQuery, defaultQuery } from './types';
+import { DataSourceWithBackend, getBackendSrv, getTemplateSrv, toDataQueryResponse } from '@grafana/runtime';
+import {
+  ExportFormat,
+  VerticaCursorPage,
+  VerticaDataSourceOptions,
+  VerticaExplainResult,
+  VerticaExportResult,
+  VerticaQuery,
+  defaultQuery,
+} from './types';
 import { Observable, Subscriber, merge } from 'rxjs';
 import { defaults } from 'lodash';
 import { switchMap as switchMap$ } from 'rxjs/operators';
 
+/*
+ *Global variables of grafana bound as parameters
+ */
+const globalVariables = [
+  '__dashboard',
+  '__dashboard.uid',
+  '__org',
+  '__org.name',
+  '__user.id',
+  '__user.login',
+  '__user.email',
+  '__interval',
+  '__rate_interval',
+  '__range',
+  '__range_s',
+  '__range_ms',
+];
+
 export class DataSource extends DataSourceWithBackend<VerticaQuery, VerticaDataSourceOptions> {
   templateSrv;
+  /*
+   *The last queries run, the ad-hoc filter keys are the columns of the tables they read from
+   */
+  lastQueries: VerticaQuery[] = [];
+  /*
+   *The time range of the last queries, the context of a log line is read within it
+   */
+  lastRange?: TimeRange;
 
   constructor(instanceSettings: DataSourceInstanceSettings<VerticaDataSourceOptions>) {
     super(instanceSettings);
     this.templateSrv = getTemplateSrv();
-    this.annotations = {};
+    /*
+     *Annotation queries run on the backend, which converts their result to annotations
+     */
+    this.annotations = {
+      prepareQuery: (anno: AnnotationQuery<VerticaQuery>) => anno.target && { ...anno.target, format: 'Annotations' },
+    };
   }
 
   applyTemplateVariables(query: VerticaQuery, scopedVars: ScopedVars): VerticaQuery {
-    query.queryTemplated = this.templateSrv.replace(query.queryString, scopedVars);
+    if (query.bindParameters) {
+      /*
+       *The variables are sent as parameter values, the backend binds $name references in the query to them
+       */
+      query.queryTemplated = query.queryString;
+      query.parameters = this.parameterValues(scopedVars);
+    } else {
+      query.queryTemplated = this.templateSrv.replace(query.queryString, scopedVars);
+    }
+    query.adhocFilters = this.templateSrv.getAdhocFilters ? this.templateSrv.getAdhocFilters(this.name) : [];
+    if (query.builder) {
+      query.builder = {
+        ...query.builder,
+        filters: query.builder.filters?.map((filter) => ({
+          ...filter,
+          value: this.templateSrv.replace(filter.value, scopedVars),
+        })),
+      };
+    }
     return query;
   }
 
-  async metricFindQuery(query: string, options?: any) {
-    const findVal: MetricFindValue[] = [];
-    if (!query) {
-      return Promise.resolve(findVal);
+  /*
+   *Returns the values of every dashboard variable, a multi-value variable has a value for every selected option,
+   *and of the global variables of grafana. the time range and interval in milliseconds are bound by the backend
+   */
+  parameterValues(scopedVars: ScopedVars): Record<string, string[]> {
+    const parameters: Record<string, string[]> = {};
+    for (const variable of this.templateSrv.getVariables()) {
+      this.templateSrv.replace('$' + variable.name, scopedVars, (value: string | string[]) => {
+        parameters[variable.name] = (Array.isArray(value) ? value : [value]).map((v) => String(v));
+        return '';
+      });
     }
-    const response = await super
-      .query({
-        targets: [
-          {
-            format: 'Table',
-            queryString: query,
-            queryTemplated: query,
-            streaming: false,
-            timeFillEnabled: false,
-            refId: 'Var',
-          },
-        ],
-      } as any)
-      .toPromise();
-
-    if (response.error) {
-      throw new Error(response.error.message);
+    for (const name of globalVariables) {
+      const reference = '${' + name + '}';
+      const value = this.templateSrv.replace(reference, scopedVars);
+      if (value !== reference) {
+        parameters[name] = [value];
+      }
     }
-    console.log(response);
-    const data = response.data[0] as DataFrame;
-    const textField = (data.length > 0 ? data.fields.find((f) => f.name === '_text') : undefined) as Field;
-    const valueField = (data.length > 0 ? data.fields.find((f) => f.name === '_value') : undefined) as Field;
+    return parameters;
+  }
 
-    switch (true) {
-      case data.fields.length > 3:
-        throw new Error(
-          `Received more than two (${data.fields.length}) fields: ${data.fields.map((x) => x.name).join(',')}`
-        );
-        break;
-      case textField === undefined && data.length > 0:
-        throw new Error(
-          `Variable query must have at least one column with name '_text' fields: ${data.fields
-            .map((x) => x.name)
-            .join(',')}`
-        );
-        break;
-    }
+  /*
+   *Returns the sql the backend renders for a builder query
+   */
+  async builderSQL(query: VerticaQuery): Promise<string> {
+    const result = await this.postResource('builder/sql', this.applyTemplateVariables({ ...query }, {}));
+    return result.sql;
+  }
 
-    if (textField !== undefined) {
-      for (let i = 0; i < data.fields[0].values.length; i++) {
-        const metricValue: MetricFindValue = {
-          text: '',
-          value: undefined,
-        } as MetricFindValue;
-        metricValue.text = textField?.values.toArray()[i];
-        if (valueField === undefined) {
-          metricValue.value = textField?.values.toArray()[i];
-        } else {
-          metricValue.value = valueField?.values.toArray()[i];
+  /*
+   *Opens a server side cursor on the result of a query and returns its first page, the next pages are read with cursorNext
+   */
+  cursorOpen(query: VerticaQuery, pageSize: number): Promise<VerticaCursorPage> {
+    return this.postResource('cursor', { ...this.applyTemplateVariables({ ...query }, {}), pageSize });
+  }
+
+  cursorNext(cursor: string): Promise<VerticaCursorPage> {
+    return this.postResource('cursor/next', { cursor });
+  }
+
+  /*
+   *Closes a cursor which is not read to the end, cursors are closed by the backend after the last page or when they expire
+   */
+  cursorClose(cursor: string): Promise<void> {
+    return this.postResource('cursor/close', { cursor });
+  }
+
+  /*
+   *Downloads the complete result of a query as csv, arrow or parquet, the backend writes it without building a frame.
+   *Returns whether the export stopped at the row limit
+   */
+  async exportQuery(query: VerticaQuery, format: ExportFormat, range?: TimeRange): Promise<VerticaExportResult> {
+    const body = {
+      ...this.applyTemplateVariables({ ...query }, {}),
+      range: range ? { from: range.from.valueOf(), to: range.to.valueOf() } : undefined,
+    };
+    // the backend srv resolves the url against the root url of grafana, which may be served under a sub path
+    const response = await getBackendSrv()
+      .fetch<Blob>({
+        url: `api/datasources/${this.id}/resources/export`,
+        method: 'POST',
+        params: { format },
+        data: body,
+        responseType: 'blob',
+        showErrorAlert: false,
+      })
+      .toPromise()
+      .catch(async (err) => {
+        // the json error of the backend arrives as a blob too
+        let error = err?.data?.error;
+        if (err?.data instanceof Blob) {
+          error = await err.data
+            .text()
+            .then((text: string) => JSON.parse(text).error)
+            .catch(() => undefined);
         }
+        throw new Error(error || err?.statusText || String(err));
+      });
+    const url = URL.createObjectURL(response.data);
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = `${query.refId || 'export'}.${format}`;
+    link.click();
+    URL.revokeObjectURL(url);
+    return {
+      truncated: response.headers.get('X-Export-Truncated') === 'true',
+      maxRows: Number(response.headers.get('X-Export-Max-Rows')),
+    };
+  }
 
-        findVal.push(metricValue);
-      }
+  /*
+   *Returns the plan of a query, with profile the query is run with PROFILE when the data source allows it
+   */
+  explain(query: VerticaQuery, profile = false): Promise<VerticaExplainResult> {
+    return this.postResource(`explain?profile=${profile}`, this.applyTemplateVariables({ ...query }, {}));
+  }
+
+  /*
+   *Variable queries run on the backend, which returns text/value pairs filtered by the search term, deduplicated and sorted.
+   *$__search and $__parent in the query are replaced by the backend with the search term and the value of an expanded parent.
+   */
+  async metricFindQuery(query: string, options?: any): Promise<MetricFindValue[]> {
+    if (!query) {
+      return [];
     }
+    const queryTemplated = this.templateSrv.replace(query, options?.scopedVars);
+    return this.postResource('variable', {
+      refId: 'Var',
+      format: 'Table',
+      queryString: query,
+      queryTemplated,
+      search: options?.searchFilter,
+      parent: options?.parent,
+      sort: options?.variable?.sort,
+    });
+  }
+
+  getTagKeys(): Promise<MetricFindValue[]> {
+    return this.postResource('adhoc/keys', { queries: this.lastQueries });
+  }
 
-    return findVal;
+  getTagValues(options: { key: string }): Promise<MetricFindValue[]> {
+    return this.postResource('adhoc/values', { queries: this.lastQueries, key: options.key });
+  }
+
+  /*
+   *Returns the log lines before or after a log line with the same labels, read by the backend from the query of the line
+   */
+  async getLogRowContext(
+    row: LogRowModel,
+    options?: { limit?: number; direction?: 'BACKWARD' | 'FORWARD' }
+  ): Promise<{ data: DataFrame[] }> {
+    const query = this.lastQueries.find((target) => target.refId === row.dataFrame.refId);
+    if (!query) {
+      return { data: [] };
+    }
+    const result = await this.postResource('logs/context', {
+      ...query,
+      range: this.lastRange ? { from: this.lastRange.from.valueOf(), to: this.lastRange.to.valueOf() } : undefined,
+      timeColumn: row.dataFrame.meta?.custom?.logs?.time,
+      bodyColumn: row.dataFrame.meta?.custom?.logs?.body,
+      time: row.timeEpochMs,
+      line: row.entry,
+      labels: row.labels,
+      direction: options?.direction || 'BACKWARD',
+      limit: options?.limit,
+    });
+    return { data: toDataQueryResponse({ data: result }).data };
+  }
+
+  /*
+   *Returns the log volume of the log queries of a request, the number of log lines per interval and level
+   */
+  getLogsVolumeDataProvider(request: DataQueryRequest<VerticaQuery>): Observable<DataQueryResponse> | undefined {
+    const targets = request.targets.filter((target) => target.format === 'Logs' && !target.hide);
+    if (!targets.length) {
+      return undefined;
+    }
+    return super.query({
+      ...request,
+      targets: targets.map((target) => ({
+        ...target,
+        refId: `volume-${target.refId}`,
+        logs: { ...target.logs, volume: true },
+      })),
+    });
   }
 
   query(options: DataQueryRequest<VerticaQuery>): Observable<DataQueryResponse> {
+    this.lastRange = options.range;
+    this.lastQueries = options.targets
+      .filter((target) => !target.hide)
+      .map((target) => ({
+        ...this.applyTemplateVariables({ ...target }, options.scopedVars),
+        adhocFilters: [],
+      }));
     /*
      *Grafana panels can have multiple queries
      *here if a panel has multiple queries we and not all of them have refresh , we throw an error forcing all the queries to have refresh enabled
@@ -154,7 +327,14 @@ export class DataSource extends DataSourceWithBackend<VerticaQuery, VerticaDataS
         return merge(...streams);
       }
       default: {
-        return super.query(options);
+        /*
+         *The dashboard and panel are sent with every query, the backend uses them to label the query in vertica
+         */
+        const { dashboardUID } = options as DataQueryRequest<VerticaQuery> & { dashboardUID?: string };
+        return super.query({
+          ...options,
+          targets: options.targets.map((target) => ({ ...target, dashboardUID, panelId: options.panelId })),
+        });
       }
     }
   }
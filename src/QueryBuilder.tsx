@@ -0,0 +1,271 @@
+import React, { FormEvent, PureComponent } from 'react';
+import { Button, InlineField, InlineFieldRow, Input, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { DataSource } from './DataSource';
+import {
+  VerticaAdhocFilter,
+  VerticaBuilderColumn,
+  VerticaBuilderOrder,
+  VerticaBuilderQuery,
+  VerticaQuery,
+} from './types';
+
+interface Props {
+  datasource: DataSource;
+  query: VerticaQuery;
+  onChange: (builder: VerticaBuilderQuery) => void;
+}
+
+interface State {
+  sql?: string;
+  sqlError?: string;
+}
+
+const aggregations: Array<SelectableValue<string>> = [
+  { label: 'none', value: '' },
+  { label: 'count', value: 'count' },
+  { label: 'count distinct', value: 'count_distinct' },
+  { label: 'sum', value: 'sum' },
+  { label: 'avg', value: 'avg' },
+  { label: 'min', value: 'min' },
+  { label: 'max', value: 'max' },
+];
+
+const operators: Array<SelectableValue<string>> = ['=', '!=', '<', '>', '=~', '!~'].map((op) => ({
+  label: op,
+  value: op,
+}));
+
+const directions: Array<SelectableValue<string>> = [
+  { label: 'ASC', value: 'ASC' },
+  { label: 'DESC', value: 'DESC' },
+];
+
+/*
+ *splitList splits a comma separated list of column names
+ */
+const splitList = (value: string): string[] =>
+  value
+    .split(',')
+    .map((item) => item.trim())
+    .filter((item) => item !== '');
+
+/*
+ *QueryBuilder edits the builder query of a query, the sql is rendered by the backend
+ */
+export class QueryBuilder extends PureComponent<Props, State> {
+  state: State = {};
+
+  builder(): VerticaBuilderQuery {
+    return this.props.query.builder || { table: '', columns: [] };
+  }
+
+  update = (change: Partial<VerticaBuilderQuery>) => {
+    this.props.onChange({ ...this.builder(), ...change });
+  };
+
+  updateColumn = (index: number, change: Partial<VerticaBuilderColumn>) => {
+    const columns = [...this.builder().columns];
+    columns[index] = { ...columns[index], ...change };
+    this.update({ columns });
+  };
+
+  updateFilter = (index: number, change: Partial<VerticaAdhocFilter>) => {
+    const filters = [...(this.builder().filters || [])];
+    filters[index] = { ...filters[index], ...change };
+    this.update({ filters });
+  };
+
+  updateOrder = (index: number, change: Partial<VerticaBuilderOrder>) => {
+    const orderBy = [...(this.builder().orderBy || [])];
+    orderBy[index] = { ...orderBy[index], ...change };
+    this.update({ orderBy });
+  };
+
+  onShowSQLClick = async () => {
+    const { datasource, query } = this.props;
+    try {
+      const sql = await datasource.builderSQL({ ...query, builder: this.builder() });
+      this.setState({ sql, sqlError: undefined });
+    } catch (err) {
+      this.setState({ sql: undefined, sqlError: err?.data?.error || err?.message || String(err) });
+    }
+  };
+
+  render() {
+    const builder = this.builder();
+    const filters = builder.filters || [];
+    const orderBy = builder.orderBy || [];
+
+    return (
+      <div className="gf-form-group">
+        <InlineFieldRow>
+          <InlineField label="Schema" tooltip="Schema of the table, the search path of the user when empty">
+            <Input
+              css={{}}
+              value={builder.schema || ''}
+              placeholder="public"
+              onChange={(e: FormEvent<HTMLInputElement>) => this.update({ schema: e.currentTarget.value })}
+            />
+          </InlineField>
+          <InlineField label="Table">
+            <Input
+              css={{}}
+              value={builder.table}
+              onChange={(e: FormEvent<HTMLInputElement>) => this.update({ table: e.currentTarget.value })}
+            />
+          </InlineField>
+          <InlineField
+            label="Time column"
+            tooltip="Limited to the dashboard time range and, when columns are aggregated, grouped by the query interval"
+          >
+            <Input
+              css={{}}
+              value={builder.timeColumn || ''}
+              onChange={(e: FormEvent<HTMLInputElement>) => this.update({ timeColumn: e.currentTarget.value })}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        {builder.columns.map((column, index) => (
+          <InlineFieldRow key={`column-${index}`}>
+            <InlineField label="Column">
+              <Input
+                css={{}}
+                value={column.name}
+                placeholder="*"
+                onChange={(e: FormEvent<HTMLInputElement>) => this.updateColumn(index, { name: e.currentTarget.value })}
+              />
+            </InlineField>
+            <InlineField label="Aggregation">
+              <Select
+                options={aggregations}
+                value={aggregations.find((a) => a.value === (column.aggregation || ''))}
+                onChange={(v: SelectableValue<string>) =>
+                  this.updateColumn(index, { aggregation: (v.value || '') as VerticaBuilderColumn['aggregation'] })
+                }
+              />
+            </InlineField>
+            <InlineField label="Alias">
+              <Input
+                css={{}}
+                value={column.alias || ''}
+                onChange={(e: FormEvent<HTMLInputElement>) => this.updateColumn(index, { alias: e.currentTarget.value })}
+              />
+            </InlineField>
+            <Button
+              variant="secondary"
+              size="md"
+              onClick={() => this.update({ columns: builder.columns.filter((_, i) => i !== index) })}
+            >
+              Remove
+            </Button>
+          </InlineFieldRow>
+        ))}
+        {filters.map((filter, index) => (
+          <InlineFieldRow key={`filter-${index}`}>
+            <InlineField label="Filter">
+              <Input
+                css={{}}
+                value={filter.key}
+                onChange={(e: FormEvent<HTMLInputElement>) => this.updateFilter(index, { key: e.currentTarget.value })}
+              />
+            </InlineField>
+            <Select
+              width={8}
+              options={operators}
+              value={operators.find((o) => o.value === filter.operator)}
+              onChange={(v: SelectableValue<string>) =>
+                this.updateFilter(index, { operator: v.value as VerticaAdhocFilter['operator'] })
+              }
+            />
+            <InlineField label="Value" tooltip="Template variables are replaced">
+              <Input
+                css={{}}
+                value={filter.value}
+                onChange={(e: FormEvent<HTMLInputElement>) => this.updateFilter(index, { value: e.currentTarget.value })}
+              />
+            </InlineField>
+            <Button
+              variant="secondary"
+              size="md"
+              onClick={() => this.update({ filters: filters.filter((_, i) => i !== index) })}
+            >
+              Remove
+            </Button>
+          </InlineFieldRow>
+        ))}
+        {orderBy.map((order, index) => (
+          <InlineFieldRow key={`order-${index}`}>
+            <InlineField label="Order by">
+              <Input
+                css={{}}
+                value={order.column}
+                onChange={(e: FormEvent<HTMLInputElement>) => this.updateOrder(index, { column: e.currentTarget.value })}
+              />
+            </InlineField>
+            <Select
+              width={10}
+              options={directions}
+              value={directions.find((d) => d.value === (order.direction || 'ASC'))}
+              onChange={(v: SelectableValue<string>) =>
+                this.updateOrder(index, { direction: v.value as VerticaBuilderOrder['direction'] })
+              }
+            />
+            <Button
+              variant="secondary"
+              size="md"
+              onClick={() => this.update({ orderBy: orderBy.filter((_, i) => i !== index) })}
+            >
+              Remove
+            </Button>
+          </InlineFieldRow>
+        ))}
+        <InlineFieldRow>
+          <InlineField label="Group by" tooltip="Comma separated columns, not aggregated columns are always grouped">
+            <Input
+              css={{}}
+              value={(builder.groupBy || []).join(', ')}
+              onChange={(e: FormEvent<HTMLInputElement>) => this.update({ groupBy: splitList(e.currentTarget.value) })}
+            />
+          </InlineField>
+          <InlineField label="Limit">
+            <Input
+              css={{}}
+              type="number"
+              value={builder.limit || ''}
+              onChange={(e: FormEvent<HTMLInputElement>) => this.update({ limit: e.currentTarget.valueAsNumber || 0 })}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <Button
+            variant="secondary"
+            size="md"
+            onClick={() => this.update({ columns: [...builder.columns, { name: '' }] })}
+          >
+            Add column
+          </Button>
+          <Button
+            variant="secondary"
+            size="md"
+            onClick={() => this.update({ filters: [...filters, { key: '', operator: '=', value: '' }] })}
+          >
+            Add filter
+          </Button>
+          <Button
+            variant="secondary"
+            size="md"
+            onClick={() => this.update({ orderBy: [...orderBy, { column: '', direction: 'ASC' }] })}
+          >
+            Add order
+          </Button>
+          <Button variant="secondary" size="md" onClick={this.onShowSQLClick}>
+            Show SQL
+          </Button>
+        </InlineFieldRow>
+        {this.state.sqlError && <pre>{this.state.sqlError}</pre>}
+        {this.state.sql && <pre>{this.state.sql}</pre>}
+      </div>
+    );
+  }
+}
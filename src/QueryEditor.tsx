@@ -1,21 +1,76 @@
 import defaults from 'lodash/defaults';
 
 import React, { FormEvent, PureComponent } from 'react';
-import { Button, InlineField, InlineFieldRow, InlineLabel, InlineSwitch, Input, Select } from '@grafana/ui';
+import { Alert, Button, InlineField, InlineFieldRow, InlineLabel, InlineSwitch, Input, Select } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from './DataSource';
-import { VerticaDataSourceOptions, VerticaQuery, defaultQuery } from './types';
+import {
+  ExportFormat,
+  VerticaAnnotationColumns,
+  VerticaBuilderQuery,
+  VerticaDataSourceOptions,
+  VerticaExplainResult,
+  VerticaPlanNode,
+  VerticaQuery,
+  defaultQuery,
+} from './types';
 import { CodeMirror } from './CodeMirror';
+import { QueryBuilder } from './QueryBuilder';
 import './styles.css';
 
 type Props = QueryEditorProps<DataSource, VerticaQuery, VerticaDataSourceOptions>;
 
-export class QueryEditor extends PureComponent<Props> {
+interface State {
+  explain?: VerticaExplainResult;
+  explainError?: string;
+  exportWarning?: string;
+}
+
+/*
+ *formatPlan prints the operators of a plan indented by their depth
+ */
+const formatPlan = (nodes: VerticaPlanNode[] = [], depth = 0): string[] =>
+  nodes.reduce((lines: string[], node) => {
+    const indent = '  '.repeat(depth);
+    let line = `${indent}${node.operator} [cost ${node.cost}, rows ${node.rows}]`;
+    if (node.projections?.length) {
+      line += ` projections: ${node.projections.join(', ')}`;
+    }
+    if (node.profile) {
+      line += ` running time ${node.profile.runningTime}, memory ${node.profile.memoryAllocatedBytes} bytes`;
+    }
+    return [...lines, line, ...formatPlan(node.children, depth + 1)];
+  }, []);
+
+/*
+ *annotationColumns are the annotation fields an annotation query maps to columns of its result
+ */
+const annotationColumns: Array<{ key: keyof VerticaAnnotationColumns; label: string; tooltip: string }> = [
+  { key: 'time', label: 'Time column', tooltip: 'Start time of the annotation, a timestamp or epoch milliseconds' },
+  { key: 'timeEnd', label: 'End column', tooltip: 'End time, annotations ending after their start are regions' },
+  { key: 'title', label: 'Title column', tooltip: 'Title of the annotation' },
+  { key: 'text', label: 'Text column', tooltip: 'Text of the annotation' },
+  { key: 'tags', label: 'Tags column', tooltip: 'Tags, a comma separated string or an ARRAY' },
+];
+
+export class QueryEditor extends PureComponent<Props, State> {
+  state: State = {};
+
   onQueryTextChange = (value: string) => {
     const { onChange, query } = this.props;
     onChange({ ...query, queryString: value });
   };
 
+  onEditorModeChange = (selectedValue: SelectableValue<string>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, editorMode: selectedValue.value === 'builder' ? 'builder' : 'code' });
+  };
+
+  onBuilderChange = (builder: VerticaBuilderQuery) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, builder });
+  };
+
   onStreamingSwitchChange = (event: FormEvent<HTMLInputElement>) => {
     const { onChange, query } = this.props;
     onChange({ ...query, streaming: event.currentTarget.checked });
@@ -36,6 +91,85 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onExplainButtonClick = async () => {
+    const { datasource, query } = this.props;
+    try {
+      const explain = await datasource.explain(query);
+      this.setState({ explain, explainError: undefined });
+    } catch (err) {
+      this.setState({ explain: undefined, explainError: err?.data?.error || err?.message || String(err) });
+    }
+  };
+
+  onExportButtonClick = async (format: ExportFormat) => {
+    const { datasource, query, range } = this.props;
+    try {
+      const result = await datasource.exportQuery(query, format, range);
+      const exportWarning = result.truncated
+        ? `The export stopped at the row limit of ${result.maxRows} rows, the result of the query has more rows.`
+        : undefined;
+      this.setState({ explainError: undefined, exportWarning });
+    } catch (err) {
+      this.setState({ explainError: err?.message || String(err), exportWarning: undefined });
+    }
+  };
+
+  onPaginationLimitChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const limit = event.currentTarget.valueAsNumber;
+    onChange({ ...query, pagination: limit > 0 ? { ...query.pagination, limit } : undefined });
+  };
+
+  onPaginationOffsetChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    if (query.pagination) {
+      onChange({ ...query, pagination: { ...query.pagination, offset: event.currentTarget.valueAsNumber || 0 } });
+    }
+  };
+
+  onPaginationKeyChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    if (query.pagination) {
+      onChange({ ...query, pagination: { ...query.pagination, key: event.currentTarget.value } });
+    }
+  };
+
+  onPaginationTieBreakerChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    if (query.pagination) {
+      onChange({ ...query, pagination: { ...query.pagination, tieBreaker: event.currentTarget.value } });
+    }
+  };
+
+  onBindParametersChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, bindParameters: event.currentTarget.checked });
+  };
+
+  onAnnotationColumnChange = (key: keyof VerticaAnnotationColumns) => (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, annotation: { ...query.annotation, [key]: event.currentTarget.value } });
+  };
+
+  onLogsColumnChange = (key: 'time' | 'body' | 'level') => (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, logs: { ...query.logs, [key]: event.currentTarget.value } });
+  };
+
+  onLogsLabelsChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const labels = event.currentTarget.value
+      .split(',')
+      .map((label) => label.trim())
+      .filter((label) => label !== '');
+    onChange({ ...query, logs: { ...query.logs, labels } });
+  };
+
+  onResultSetsChange = (selectedValue: SelectableValue<string>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, resultSets: selectedValue.value === 'last' ? 'last' : 'all' });
+  };
+
   onTimeFillModeValueChange = (selectedValue: SelectableValue<string>) => {
     const { onChange, query } = this.props;
     let val: 'static' | 'null' | 'previous';
@@ -61,6 +195,12 @@ export class QueryEditor extends PureComponent<Props> {
       case 'Table':
         onChange({ ...query, format: 'Table', streaming: false, timeFillEnabled: false });
         break;
+      case 'Annotations':
+        onChange({ ...query, format: 'Annotations', streaming: false, timeFillEnabled: false });
+        break;
+      case 'Logs':
+        onChange({ ...query, format: 'Logs', streaming: false, timeFillEnabled: false });
+        break;
       default:
         onChange({ ...query, format: 'Time Series' });
     }
@@ -71,14 +211,58 @@ export class QueryEditor extends PureComponent<Props> {
     onChange({ ...query, timeFillStaticValue: event.currentTarget.valueAsNumber });
   };
 
+  onResourcePoolChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, resourcePool: event.currentTarget.value });
+  };
+
+  onWorkloadChange = (event: FormEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, workload: event.currentTarget.value });
+  };
+
   render() {
     const query = defaults(this.props.query, defaultQuery),
-      { queryString, streaming, streamingInterval, timeFillEnabled, timeFillMode, timeFillStaticValue, format } = query;
+      {
+        queryString,
+        streaming,
+        streamingInterval,
+        timeFillEnabled,
+        timeFillMode,
+        timeFillStaticValue,
+        format,
+        resourcePool,
+        workload,
+        editorMode,
+        pagination,
+        resultSets,
+        bindParameters,
+        annotation,
+        logs,
+      } = query;
 
     return (
       <div className="gf-form-group">
-        <InlineLabel width="auto"> Query </InlineLabel>
-        <CodeMirror content={queryString} onContentChange={this.onQueryTextChange} />
+        <InlineFieldRow>
+          <InlineField label="Editor" tooltip="Write sql or build the query, builder queries are rendered to sql by the backend">
+            <Select
+              options={[
+                { label: 'Code', value: 'code' },
+                { label: 'Builder', value: 'builder' },
+              ]}
+              value={{ label: editorMode === 'builder' ? 'Builder' : 'Code', value: editorMode || 'code' }}
+              onChange={this.onEditorModeChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        {editorMode === 'builder' ? (
+          <QueryBuilder datasource={this.props.datasource} query={query} onChange={this.onBuilderChange} />
+        ) : (
+          <>
+            <InlineLabel width="auto"> Query </InlineLabel>
+            <CodeMirror content={queryString} onContentChange={this.onQueryTextChange} />
+          </>
+        )}
         <div className="gf-form">
           <InlineFieldRow>
             <InlineField label="QueryType" tooltip="Query type">
@@ -86,6 +270,8 @@ export class QueryEditor extends PureComponent<Props> {
                 options={[
                   { label: 'Time Series', value: 'Time Series' },
                   { label: 'Table', value: 'Table' },
+                  { label: 'Annotations', value: 'Annotations' },
+                  { label: 'Logs', value: 'Logs' },
                 ]}
                 value={{ label: format || 'Time Series', value: format || 'Time Series' }}
                 onChange={this.onQueryTypeChange}
@@ -140,13 +326,133 @@ export class QueryEditor extends PureComponent<Props> {
             )}
           </InlineFieldRow>
         </div>
+        {format === 'Annotations' && (
+          <div className="gf-form">
+            <InlineFieldRow>
+              {annotationColumns.map(({ key, label, tooltip }) => (
+                <InlineField key={key} label={label} tooltip={tooltip}>
+                  <Input
+                    css={{}}
+                    value={annotation?.[key] || ''}
+                    placeholder={key}
+                    onChange={this.onAnnotationColumnChange(key)}
+                  />
+                </InlineField>
+              ))}
+            </InlineFieldRow>
+          </div>
+        )}
+        {format === 'Logs' && (
+          <div className="gf-form">
+            <InlineFieldRow>
+              <InlineField label="Time column" tooltip="Time of the log lines, the time or first timestamp column by default">
+                <Input css={{}} value={logs?.time || ''} placeholder="time" onChange={this.onLogsColumnChange('time')} />
+              </InlineField>
+              <InlineField label="Body column" tooltip="Log line, the body, message, msg, line or log column by default">
+                <Input css={{}} value={logs?.body || ''} placeholder="body" onChange={this.onLogsColumnChange('body')} />
+              </InlineField>
+              <InlineField
+                label="Level column"
+                tooltip="Level of the log lines, mapped to grafana log levels, the level or severity column by default"
+              >
+                <Input css={{}} value={logs?.level || ''} placeholder="level" onChange={this.onLogsColumnChange('level')} />
+              </InlineField>
+              <InlineField label="Label columns" tooltip="Comma separated columns whose values are the labels of the log lines">
+                <Input css={{}} defaultValue={(logs?.labels || []).join(', ')} onBlur={this.onLogsLabelsChange} />
+              </InlineField>
+            </InlineFieldRow>
+          </div>
+        )}
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineField
+              label="Resource pool"
+              tooltip="Vertica resource pool the query runs in, has to be allowed in the data source settings"
+            >
+              <Input css={{}} value={resourcePool || ''} placeholder="default" onChange={this.onResourcePoolChange} />
+            </InlineField>
+            <InlineField
+              label="Workload"
+              tooltip="Vertica workload used to route the query to a subcluster, has to be allowed in the data source settings"
+            >
+              <Input css={{}} value={workload || ''} placeholder="default" onChange={this.onWorkloadChange} />
+            </InlineField>
+            <InlineField
+              label="Result sets"
+              tooltip="A query with several statements separated by ; returns a frame for the result of every statement, or only for the last one"
+            >
+              <Select
+                options={[
+                  { label: 'All', value: 'all' },
+                  { label: 'Last', value: 'last' },
+                ]}
+                value={{ label: resultSets === 'last' ? 'Last' : 'All', value: resultSets || 'all' }}
+                onChange={this.onResultSetsChange}
+              />
+            </InlineField>
+            <InlineField
+              label="Bind variables"
+              tooltip="Send $variable references as bind parameters instead of replacing them in the query text"
+            >
+              <InlineSwitch value={bindParameters || false} css={{}} onChange={this.onBindParametersChange} />
+            </InlineField>
+            {format === 'Table' && (
+              <InlineField label="Page size" tooltip="Return a page of the result, the query needs an ORDER BY or a key column. The total row count of the first page is in the frame meta">
+                <Input
+                  css={{}}
+                  type="number"
+                  value={pagination?.limit || ''}
+                  placeholder="all rows"
+                  onChange={this.onPaginationLimitChange}
+                />
+              </InlineField>
+            )}
+            {format === 'Table' && pagination && (
+              <InlineField label="Offset">
+                <Input css={{}} type="number" value={pagination.offset || 0} onChange={this.onPaginationOffsetChange} />
+              </InlineField>
+            )}
+            {format === 'Table' && pagination && (
+              <InlineField label="Key" tooltip="Order the pages by this column, the next page starts after the key of the last row">
+                <Input css={{}} value={pagination.key || ''} onChange={this.onPaginationKeyChange} />
+              </InlineField>
+            )}
+            {format === 'Table' && pagination?.key && (
+              <InlineField
+                label="Tie breaker"
+                tooltip="A column ordering the rows with the same key, needed when the key is not unique, otherwise rows are skipped"
+              >
+                <Input css={{}} value={pagination.tieBreaker || ''} onChange={this.onPaginationTieBreakerChange} />
+              </InlineField>
+            )}
+          </InlineFieldRow>
+        </div>
         <div className="gf-form">
           <InlineFieldRow>
             <Button variant="primary" size="md" onClick={this.onRunButtonClick}>
               Run
             </Button>
+            <Button variant="secondary" size="md" onClick={this.onExplainButtonClick}>
+              Explain
+            </Button>
+            <Button variant="secondary" size="md" onClick={() => this.onExportButtonClick('csv')}>
+              Export CSV
+            </Button>
+            <Button variant="secondary" size="md" onClick={() => this.onExportButtonClick('arrow')}>
+              Export Arrow
+            </Button>
+            <Button variant="secondary" size="md" onClick={() => this.onExportButtonClick('parquet')}>
+              Export Parquet
+            </Button>
           </InlineFieldRow>
         </div>
+        {this.state.exportWarning && (
+          <Alert severity="warning" title="Export truncated">
+            {this.state.exportWarning}
+          </Alert>
+        )}
+        {this.state.explainError && <pre>{this.state.explainError}</pre>}
+        {this.state.explain && <pre>{formatPlan(this.state.explain.plan).join('\n')}</pre>}
       </div>
     );
   }
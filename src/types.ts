@@ -1,7 +1,7 @@
 import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
 export interface VerticaQuery extends DataQuery {
-  format: 'Time Series' | 'Table';
+  format: 'Time Series' | 'Table' | 'Annotations' | 'Logs';
   queryString: string;
   queryTemplated: string;
   streaming: boolean;
@@ -9,6 +9,96 @@ export interface VerticaQuery extends DataQuery {
   timeFillEnabled: boolean;
   timeFillMode: 'static' | 'null' | 'previous';
   timeFillStaticValue: number;
+  resourcePool?: string;
+  workload?: string;
+  dashboardUID?: string;
+  panelId?: number;
+  adhocFilters?: VerticaAdhocFilter[];
+  editorMode?: 'code' | 'builder';
+  builder?: VerticaBuilderQuery;
+  pagination?: VerticaPagination;
+  resultSets?: 'all' | 'last';
+  bindParameters?: boolean;
+  parameters?: Record<string, string[]>;
+  annotation?: VerticaAnnotationColumns;
+  logs?: VerticaLogsOptions;
+}
+
+/*
+ *Columns of the result of a log query read as the log lines, empty columns are detected by the backend
+ */
+export interface VerticaLogsOptions {
+  time?: string;
+  body?: string;
+  level?: string;
+  labels?: string[];
+  volume?: boolean;
+}
+
+/*
+ *Columns of the result of an annotation query read as the annotation fields, empty columns default to the field names
+ */
+export interface VerticaAnnotationColumns {
+  time?: string;
+  timeEnd?: string;
+  title?: string;
+  text?: string;
+  tags?: string;
+}
+
+export interface VerticaPagination {
+  limit: number;
+  offset?: number;
+  key?: string;
+  after?: string;
+  descending?: boolean;
+  count?: boolean;
+  tieBreaker?: string;
+  afterTieBreaker?: string;
+}
+
+export interface VerticaPageInfo {
+  limit: number;
+  offset: number;
+  total?: number;
+  next?: string;
+  nextTieBreaker?: string;
+}
+
+export interface VerticaCursorPage {
+  cursor?: string;
+  columns: string[];
+  rows: any[][];
+  offset: number;
+  done: boolean;
+}
+
+export interface VerticaBuilderQuery {
+  schema?: string;
+  table: string;
+  columns: VerticaBuilderColumn[];
+  timeColumn?: string;
+  groupBy?: string[];
+  filters?: VerticaAdhocFilter[];
+  orderBy?: VerticaBuilderOrder[];
+  limit?: number;
+}
+
+export interface VerticaBuilderColumn {
+  name: string;
+  aggregation?: '' | 'count' | 'count_distinct' | 'sum' | 'avg' | 'min' | 'max';
+  alias?: string;
+}
+
+export interface VerticaBuilderOrder {
+  column: string;
+  direction?: 'ASC' | 'DESC';
+}
+
+export interface VerticaAdhocFilter {
+  key: string;
+  operator: '=' | '!=' | '<' | '>' | '=~' | '!~';
+  value: string;
 }
 
 export const defaultQuery: Partial<VerticaQuery> = {
@@ -29,19 +119,137 @@ export const defaultQuery: Partial<VerticaQuery> = {
   timeFillEnabled: false,
 };
 
+/**
+ * Formats of the export resource route
+ */
+export type ExportFormat = 'csv' | 'arrow' | 'parquet';
+
+/**
+ * Row limit of an export and whether the export stopped at it
+ */
+export interface VerticaExportResult {
+  truncated: boolean;
+  maxRows: number;
+}
+
+/**
+ * Plan of a query returned by the explain resource route
+ */
+export interface VerticaExplainResult {
+  plan: VerticaPlanNode[];
+  text: string[];
+  profiled: boolean;
+}
+
+export interface VerticaPlanNode {
+  pathId: number;
+  operator: string;
+  cost: number;
+  rows: number;
+  projections?: string[];
+  details?: string[];
+  profile?: VerticaPathProfile;
+  children?: VerticaPlanNode[];
+}
+
+export interface VerticaPathProfile {
+  runningTime: string;
+  memoryAllocatedBytes: number;
+  readFromDiskBytes: number;
+  receivedBytes: number;
+  sentBytes: number;
+}
+
 /**
  * These are options configured for each DataSource instance
  */
 export interface VerticaDataSourceOptions extends DataSourceJsonData {
   host: string;
+  backupHosts?: string[];
   database: string;
   user: string;
+  authType?: 'password' | 'kerberos' | 'oauth';
+  oauth?: VerticaOAuth;
+  passwordSource?: VerticaPasswordSource;
   sslMode: 'none' | 'server' | 'server-strict';
   usePreparedStatement: boolean;
   useConnectionLoadbalancing: boolean;
   maxOpenConnections: number;
   maxIdealConnections: number;
   maxConnectionIdealTime: number;
+  maxConnectionLifetime?: number;
+  workload?: string;
+  allowedWorkloads?: string[];
+  allowedResourcePools?: string[];
+  userIdentity?: VerticaUserIdentity;
+  queryLabel?: VerticaQueryLabel;
+  sessionParameters?: VerticaSessionParameters;
+  initStatements?: string[];
+  auditLog?: VerticaAuditLog;
+  slowQuery?: VerticaSlowQuery;
+  allowProfile?: boolean;
+}
+
+/**
+ * Password read from a file or environment variable, read again every refreshInterval seconds
+ */
+export interface VerticaPasswordSource {
+  file?: string;
+  env?: string;
+  refreshInterval?: number;
+}
+
+/**
+ * OAuth access token authentication, tokens are requested with the client credentials grant or forwarded from grafana
+ */
+export interface VerticaOAuth {
+  tokenUrl?: string;
+  clientId?: string;
+  scopes?: string[];
+  forwardUserToken?: boolean;
+}
+
+/**
+ * Forwarding of the grafana user, 'connection' logs in as the mapped vertica user, 'role' sets the mapped roles
+ */
+export interface VerticaUserIdentity {
+  mode?: 'connection' | 'role';
+  users?: Record<string, string>;
+  roles?: Record<string, string[]>;
+}
+
+/**
+ * Label added to every query, mode 'hint' adds a LABEL hint, 'clientLabel' sets the session client label
+ */
+export interface VerticaQueryLabel {
+  mode?: 'hint' | 'clientLabel' | 'both';
+  template?: string;
+}
+
+/**
+ * Session settings applied to every new connection
+ */
+export interface VerticaSessionParameters {
+  resourcePool?: string;
+  searchPath?: string;
+  timeZone?: string;
+  clientLabel?: string;
+}
+
+/**
+ * Audit log of the statements executed for grafana users
+ */
+export interface VerticaAuditLog {
+  enabled?: boolean;
+  redactLiterals?: boolean;
+}
+
+/**
+ * Reporting of queries running longer than the threshold
+ */
+export interface VerticaSlowQuery {
+  thresholdMs?: number;
+  plan?: '' | 'explain' | 'profile';
 }
 
 /**
@@ -49,4 +257,5 @@ export interface VerticaDataSourceOptions extends DataSourceJsonData {
  */
 export interface VerticaSecureJsonData {
   password: string;
+  oauthClientSecret?: string;
 }